@@ -1,5 +1,3 @@
 module experimental/dwat/gosense
 
 go 1.18
-
-require github.com/mdlayher/lmsensors v0.0.0-20161014185602-a2b39d81fa73 // indirect
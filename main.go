@@ -1,22 +1,140 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 
 	"experimental/dwat/gosense/pkg/cache"
 	"experimental/dwat/gosense/pkg/lmsensors"
 	"experimental/dwat/gosense/pkg/lmsensors/classic"
+	"experimental/dwat/gosense/pkg/platform"
+	"experimental/dwat/gosense/pkg/report"
 )
 
 const (
 	serverAddr      = ":8080" // Address and port for the http server to listen on
 	defaultInterval = 60      // Number of seconds between attempts to update the cache
+	statusPattern   = "/api/sys/status"
+	metricsPattern  = "/metrics"
 )
 
+// caches holds every cache which started successfully.
+var caches = struct {
+	sync.Mutex
+	started []*cache.Cache
+	decode  map[*cache.Cache]report.Decode
+}{decode: make(map[*cache.Cache]report.Decode)}
+
+// monitor describes a cache and where to serve it.
+type monitor struct {
+	name      string             // Name of the cache
+	update    cache.Update       // Update function populating the cache
+	metadata  cache.Metadata     // Metadata describing the monitor, or nil
+	usage     func() cache.Usage // Usage of processes run by the monitor, or nil
+	decode    report.Decode      // Decode converts the cache to readings, or nil
+	rewrite   report.Rewrite     // Rewrite rewrites the readings in the cache, or nil
+	native    native             // Native renders the cache in the native format, or nil to serve the cache
+	classic   bool               // Classic is set if the cache holds a ClassicReport
+	preferred report.Formatter   // Preferred format if the client has no preference, or nil for native
+	pattern   string             // Pattern the cache is served at
+	v2pattern string             // Pattern the cache is served at as a report.SensorsReport
+}
+
+// native renders the data of a cache in the native format of its monitor, with
+// only the readings selected by filter.
+type native func(data []byte, filter report.Filter) ([]byte, error)
+
+// status is served at statusPattern.
+type status struct {
+	Self     cache.Usage    `json:"self"`     // Resources used by gosense
+	Children cache.Usage    `json:"children"` // Resources used by all processes gosense has run
+	Monitors []cache.Status `json:"monitors"` // Status of every monitor which started
+}
+
 func main() {
-	startAndRegister("csensors", classic.Update, "/api/sys/sensors")
-	startAndRegister("sensors", lmsensors.Update, "/api/sys/sensors2")
+	flag.StringVar(&classic.SHA256, "sensors-sha256", "", "expected SHA-256 digest of the sensors executable")
+	sensorsUser := flag.String("sensors-user", "", "unprivileged user to run the sensors executable as")
+	sensorsMode := flag.String("sensors-mode", "auto", "how to read sensors: auto (json if supported, else thresholds), classic, thresholds (classic with thresholds and alarms), raw (sensors -u) or json (sensors -j)")
+	sensorsChips := flag.String("sensors-chips", "", "comma separated chips for sensors to scan, e.g. tmp75-*,coretemp-isa-0000, instead of every chip")
+	platformName := flag.String("platform", "", "platform whose sensor names, locations and corrections to apply to every monitor, e.g. wedge100")
+	flag.StringVar(&lmsensors.Root, "sysfs-root", lmsensors.Root, "where sysfs is mounted, e.g. /host/sys in a container")
+	recordDir := flag.String("record-fixtures", "", "directory to record the output of every command to")
+	replayDir := flag.String("replay-fixtures", "", "directory to replay recorded command output from instead of running commands")
+	flag.Parse()
+
+	switch {
+	case *recordDir != "" && *replayDir != "":
+		log.Fatalf("Only one of -record-fixtures and -replay-fixtures may be set")
+	case *recordDir != "":
+		cache.SetFixtures(cache.FixtureRecord, *recordDir)
+	case *replayDir != "":
+		cache.SetFixtures(cache.FixtureReplay, *replayDir)
+	}
+
+	if *sensorsChips != "" {
+		classic.Chips = strings.Split(*sensorsChips, ",")
+	}
+
+	if *sensorsUser != "" {
+		credential, err := cache.LookupCredential(*sensorsUser)
+		if err != nil {
+			log.Fatalf("Failed to look up user %s, err %v", *sensorsUser, err)
+		}
+		classic.Credential = credential
+	}
+
+	// Pin sensors before serving so that an executable which does not match
+	// -sensors-sha256 stops gosense instead of failing every update. Replayed
+	// fixtures do not need the executable.
+	if *replayDir == "" {
+		err := classic.Resolve()
+		switch {
+		case errors.Is(err, cache.ErrDigestMismatch), errors.Is(err, cache.ErrInsecureExecutable):
+			log.Fatalf("Failed to pin sensors, err %v", err)
+		case err != nil:
+			log.Printf("Failed to pin sensors, err %v", err)
+		}
+	}
+
+	csensors := monitor{name: "csensors", update: classic.Update, metadata: classic.Metadata, usage: classic.Usage, decode: classic.Decode, rewrite: report.RewriteClassic, classic: true, pattern: "/api/sys/sensors", v2pattern: "/api/v2/sys/sensors"}
+	switch *sensorsMode {
+	case "auto":
+		csensors.update = new(classic.Auto).Update
+	case "classic":
+	case "thresholds":
+		csensors.update = classic.UpdateReadings
+	case "raw":
+		csensors.update = classic.UpdateRaw
+	case "json":
+		csensors.update = classic.UpdateJSON
+	default:
+		log.Fatalf("Unknown -sensors-mode %s", *sensorsMode)
+	}
+	if *sensorsMode != "classic" {
+		// The cache holds readings, so derive the classic format from them.
+		csensors.classic = false
+		csensors.rewrite = report.RewriteReadings
+		csensors.preferred = report.Classic
+	}
+	sensors := monitor{name: "sensors", update: lmsensors.UpdateAll, decode: lmsensors.Decode, rewrite: lmsensors.Rewrite, native: lmsensors.FormatDevices, pattern: "/api/sys/sensors2", v2pattern: "/api/v2/sys/sensors2"}
+	if *platformName != "" {
+		config, err := platform.Lookup(*platformName)
+		if err != nil {
+			log.Fatalf("Failed to look up -platform, err %v", err)
+		}
+		for _, m := range []*monitor{&csensors, &sensors} {
+			m.update = config.Update(m.update, m.rewrite)
+		}
+	}
+	startAndRegister(csensors)
+	startAndRegister(sensors)
+	http.HandleFunc(statusPattern, serveStatus)
+	http.HandleFunc(metricsPattern, serveMetrics)
 
 	log.Fatal(http.ListenAndServe(serverAddr, nil))
 }
@@ -24,13 +142,216 @@ func main() {
 // startAndRegister uses a goroutine to start each cache concurrently, only
 // registering a handler on success. This helps ensure monitoring starts as
 // quickly as possible in an emergency.
-func startAndRegister(name string, update cache.Update, pattern string) {
+func startAndRegister(m monitor) {
 	go func() {
-		c := cache.NewCache(name, update, defaultInterval).Start()
-		if c != nil {
-			http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-				w.Write(c.Get())
+		c := cache.NewCache(m.name, m.update, defaultInterval)
+		c.Metadata = m.metadata
+		c.Usage = m.usage
+		if c.Start() != nil {
+			caches.Lock()
+			caches.started = append(caches.started, c)
+			caches.decode[c] = m.decode
+			caches.Unlock()
+
+			http.HandleFunc(m.pattern, func(w http.ResponseWriter, r *http.Request) {
+				serveMonitor(w, r, m, c)
 			})
+			if m.decode != nil {
+				http.HandleFunc(m.v2pattern, func(w http.ResponseWriter, r *http.Request) {
+					serveFiltered(w, r, m, c, report.Normalized)
+				})
+			}
 		}
 	}()
 }
+
+// serveMonitor serves the cache c of the monitor m in the format selected by
+// ?format= or the Accept header, or in its preferred format.
+func serveMonitor(w http.ResponseWriter, r *http.Request, m monitor, c *cache.Cache) {
+	preferred := m.preferred
+	if preferred == nil {
+		preferred = report.Native
+	}
+	f, err := report.Negotiate(r.URL.Query().Get("format"), r.Header.Get("Accept"), preferred)
+	if err != nil {
+		serveError(w, http.StatusNotAcceptable, err)
+		return
+	}
+
+	serveFiltered(w, r, m, c, f)
+}
+
+// serveFiltered serves the cache c of the monitor m formatted by f, with only
+// the readings selected by the ?chip=, ?label= and ?kind= filters. The native
+// format can only be filtered if it is a ClassicReport or rendered by the
+// monitor.
+func serveFiltered(w http.ResponseWriter, r *http.Request, m monitor, c *cache.Cache, f report.Formatter) {
+	filter, err := report.ParseFilter(r.URL.Query())
+	if err == nil && !filter.Empty() && f == report.Native && !m.classic && m.native == nil {
+		err = report.ErrUnfilterable
+	}
+	if err != nil {
+		serveError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	serveFormat(w, m, c, f, filter)
+}
+
+// serveFormat serves the cache c of the monitor m formatted by f, with only
+// the readings selected by filter. Errors are always served as JSON.
+func serveFormat(w http.ResponseWriter, m monitor, c *cache.Cache, f report.Formatter, filter report.Filter) {
+	snapshot := c.Snapshot()
+	if snapshot.Err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(snapshot.Err.StatusCode())
+		w.Write(snapshot.Data)
+		return
+	}
+
+	d := report.Document{Monitor: m.name, Time: snapshot.Time, Data: snapshot.Data, Classic: m.classic}
+	if m.classic && !filter.Empty() {
+		var classic report.ClassicReport
+		if err := json.Unmarshal(snapshot.Data, &classic); err != nil {
+			serveError(w, http.StatusInternalServerError, err)
+			return
+		}
+		encoded, err := json.Marshal(filter.ApplyClassic(classic))
+		if err != nil {
+			serveError(w, http.StatusInternalServerError, err)
+			return
+		}
+		d.Data = encoded
+	}
+	if f != report.Native && m.decode != nil {
+		readings, err := m.decode(snapshot.Data)
+		if err != nil {
+			serveError(w, http.StatusInternalServerError, err)
+			return
+		}
+		d.Readings = filter.Apply(readings)
+	}
+	if f == report.Native && m.native != nil {
+		encoded, err := m.native(snapshot.Data, filter)
+		if err != nil {
+			serveError(w, http.StatusInternalServerError, err)
+			return
+		}
+		d.Data = encoded
+	}
+
+	w.Header().Set("Content-Type", f.ContentType())
+	if err := f.Format(w, d); err != nil {
+		log.Printf("Failed to write %s as %s, err: %v.\n", m.name, f.Name(), err)
+	}
+}
+
+// serveError serves err as JSON with the status code.
+func serveError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(cache.FormatError(err))
+}
+
+// serveStatus reports the status of gosense and every cache which started.
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	var s status
+	var err error
+	s.Self, s.Children, err = cache.SelfUsage()
+	if err != nil {
+		log.Printf("Failed to get resource usage, err: %v.\n", err)
+	}
+
+	caches.Lock()
+	s.Monitors = make([]cache.Status, 0, len(caches.started))
+	for _, c := range caches.started {
+		s.Monitors = append(s.Monitors, c.Status())
+	}
+	caches.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(cache.FormatError(err))
+		return
+	}
+	w.Write(encoded)
+}
+
+// serveMetrics reports every sensor reading, and the health of gosense and
+// every cache which started, in the Prometheus text format or in OpenMetrics
+// if the scraper accepts it.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	m := report.NewMetrics()
+
+	caches.Lock()
+	for _, c := range caches.started {
+		addMonitorMetrics(m, c, caches.decode[c])
+	}
+	caches.Unlock()
+
+	self, children, err := cache.SelfUsage()
+	if err != nil {
+		log.Printf("Failed to get resource usage, err: %v.\n", err)
+	} else {
+		addUsageMetrics(m, "gosense_self", self)
+		addUsageMetrics(m, "gosense_children", children)
+	}
+
+	write := m.WritePrometheus
+	w.Header().Set("Content-Type", report.PrometheusContentType)
+	if report.AcceptsOpenMetrics(r.Header.Get("Accept")) {
+		write = m.WriteOpenMetrics
+		w.Header().Set("Content-Type", report.OpenMetricsContentType)
+	}
+	if err := write(w); err != nil {
+		log.Printf("Failed to write metrics, err: %v.\n", err)
+	}
+}
+
+// addMonitorMetrics adds the health of the cache c, and its readings if it can
+// be decoded.
+func addMonitorMetrics(m *report.Metrics, c *cache.Cache, decode report.Decode) {
+	snapshot := c.Snapshot()
+	monitor := report.Label{Name: "monitor", Value: c.Name}
+
+	up := 1.0
+	if snapshot.Err != nil {
+		up = 0
+	}
+	m.AddSample("gosense_monitor_up", report.Gauge, "Whether the last update of the monitor succeeded.",
+		report.Sample{Labels: []report.Label{monitor}, Value: up, Timestamp: snapshot.Time})
+	if !snapshot.Time.IsZero() {
+		m.Add("gosense_monitor_last_update_timestamp_seconds", report.Gauge, "Time of the last update of the monitor.",
+			float64(snapshot.Time.UnixNano())/1e9, monitor)
+	}
+	m.Add("gosense_monitor_update_duration_seconds", report.Gauge, "Duration of the last update of the monitor.",
+		snapshot.Duration.Seconds(), monitor)
+	m.Add("gosense_monitor_updates_total", report.Counter, "Number of updates of the monitor.",
+		float64(snapshot.Updates), monitor)
+	m.Add("gosense_monitor_update_failures_total", report.Counter, "Number of failed updates of the monitor.",
+		float64(snapshot.Failures), monitor)
+	if c.Usage != nil {
+		usage := c.Usage()
+		m.Add("gosense_monitor_child_cpu_seconds_total", report.Counter, "CPU time used by processes run by the monitor.",
+			(usage.UserTime + usage.SystemTime).Seconds(), monitor)
+	}
+
+	if decode == nil || snapshot.Err != nil {
+		return
+	}
+	readings, err := decode(snapshot.Data)
+	if err != nil {
+		log.Printf("Failed to decode %s, err: %v.\n", c.Name, err)
+		return
+	}
+	m.AddReadings(c.Name, snapshot.Time, readings)
+}
+
+// addUsageMetrics adds the resources in usage, with names starting with prefix.
+func addUsageMetrics(m *report.Metrics, prefix string, usage cache.Usage) {
+	m.Add(prefix+"_cpu_seconds_total", report.Counter, "CPU time used.", (usage.UserTime + usage.SystemTime).Seconds())
+	m.Add(prefix+"_max_rss_bytes", report.Gauge, "Largest resident set size.", float64(usage.MaxRSS)*1024)
+	m.Add(prefix+"_major_faults_total", report.Counter, "Number of major page faults.", float64(usage.MajorFaults))
+}
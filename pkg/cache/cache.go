@@ -16,6 +16,7 @@ package cache
 
 import (
 	"log"
+	"runtime/debug"
 	"sync/atomic"
 	"time"
 )
@@ -33,8 +34,38 @@ type cacher interface {
 type Cache struct {
 	Name     string       // Name of the cache
 	update   Update       // update generates the data used to populate the cache
-	data     atomic.Value // data is an atomically updated []byte representation
+	data     atomic.Value // data is an atomically updated *Snapshot
 	Interval int          // Interval (in seconds) determines how often the cache is refreshed
+	Metadata Metadata     // Metadata optionally describes the monitor
+	Usage    func() Usage // Usage optionally reports resources used by processes the monitor runs
+	retrying bool         // retrying is true once the cache has been started
+}
+
+// Metadata returns information about a monitor, for example the executable it
+// runs.
+type Metadata func() map[string]string
+
+// Status describes the state of a cache.
+type Status struct {
+	Name     string            `json:"name"`               // Name of the cache
+	Interval int               `json:"interval"`           // Interval (in seconds) between updates
+	Metadata map[string]string `json:"metadata,omitempty"` // Metadata about the monitor
+	Usage    *Usage            `json:"usage,omitempty"`    // Usage of processes run by the monitor
+	Updated  time.Time         `json:"updated"`            // Updated is when the last update finished
+	Duration time.Duration     `json:"duration_ns"`        // Duration of the last update
+	Updates  int64             `json:"updates"`            // Updates is the number of updates so far
+	Failures int64             `json:"failures"`           // Failures is the number of updates which failed
+	Err      *ErrorReport      `json:"err,omitempty"`      // Err describes why the last update failed
+}
+
+// Snapshot is a consistent view of the cache at a point in time.
+type Snapshot struct {
+	Data     []byte        // Data is the representation served to clients
+	Err      *ErrorReport  // Err describes why the last update failed, or is nil
+	Time     time.Time     // Time is when the last update finished, zero if there has not been one
+	Duration time.Duration // Duration is how long the last update took
+	Updates  int64         // Updates is the number of updates so far
+	Failures int64         // Failures is the number of updates which failed
 }
 
 // NewCache allocates and initializes a Cache.
@@ -45,7 +76,7 @@ func NewCache(name string, update Update, nSeconds int) *Cache {
 		Interval: nSeconds,
 	}
 
-	cache.data.Store([]byte(UnknownValue))
+	cache.data.Store(&Snapshot{Data: []byte(UnknownValue)})
 	return &cache
 }
 
@@ -59,6 +90,7 @@ func (c *Cache) Start() *Cache {
 		return nil
 	}
 
+	c.retrying = true
 	ticker := time.NewTicker(time.Duration(c.Interval) * time.Second)
 
 	go func() {
@@ -75,20 +107,79 @@ func (c *Cache) Start() *Cache {
 // Get returns a consistent slice of byte(s). Note that the underlying memory
 // is not protected and assumed to be immutable.
 func (c *Cache) Get() []byte {
-	return c.data.Load().([]byte)
+	return c.Snapshot().Data
+}
+
+// Snapshot returns the data and error state of the cache as of the last
+// update.
+func (c *Cache) Snapshot() *Snapshot {
+	return c.data.Load().(*Snapshot)
+}
+
+// Status returns the current status of the cache.
+func (c *Cache) Status() Status {
+	snapshot := c.Snapshot()
+	status := Status{
+		Name:     c.Name,
+		Interval: c.Interval,
+		Updated:  snapshot.Time,
+		Duration: snapshot.Duration,
+		Updates:  snapshot.Updates,
+		Failures: snapshot.Failures,
+		Err:      snapshot.Err,
+	}
+	if c.Metadata != nil {
+		status.Metadata = c.Metadata()
+	}
+	if c.Usage != nil {
+		usage := c.Usage()
+		status.Usage = &usage
+	}
+
+	return status
+}
+
+// store replaces the cache contents with data, or with a report describing err
+// if it is not nil, for an update which began at start.
+func (c *Cache) store(data []byte, err error, start time.Time) {
+	previous := c.Snapshot()
+	now := time.Now()
+	snapshot := &Snapshot{
+		Data:     data,
+		Time:     now,
+		Duration: now.Sub(start),
+		Updates:  previous.Updates + 1,
+		Failures: previous.Failures,
+	}
+	if err != nil {
+		snapshot.Err = NewErrorReport(err, c.retrying)
+		snapshot.Data = snapshot.Err.Encode()
+		snapshot.Failures++
+	}
+
+	c.data.Store(snapshot)
 }
 
 // UpdateWithTimeout calls update asyncronously with a timeout. If action times
 // out a message will be logged and the process will optionally panic to avoid
 // leaking goroutines (and possibly recover from bad state). After this
 // function returns the cache will have been updated, either with new data,
-// or with an error string explaining what happened.
+// or with an error report explaining what happened. Panics in update are
+// recovered and reported as a PanicError.
 func (c *Cache) UpdateWithTimeout(deadman bool) error {
 	var err error
+	start := time.Now()
 	results := make(chan []byte, 1)
 	errs := make(chan error, 1)
 
 	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				results <- nil
+				errs <- &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+
 		result, err := c.update()
 		results <- result
 		errs <- err
@@ -96,15 +187,13 @@ func (c *Cache) UpdateWithTimeout(deadman bool) error {
 
 	select {
 	case err = <-errs:
-		if err == nil {
-			c.data.Store(<-results)
-		} else {
-			c.data.Store(FormatError(err))
+		c.store(<-results, err, start)
+		if err != nil {
 			log.Printf("Update failed, err: %v.\n", err)
 		}
 	case <-time.After(time.Duration(c.Interval) * time.Second):
 		err = error(&TimeoutError{})
-		c.data.Store(FormatError(err))
+		c.store(nil, err, start)
 		log.Printf("Update timed out\n")
 		if deadman {
 			panic("Deadman switch enabled")
@@ -15,6 +15,12 @@
 package cache_test
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os/exec"
 	"reflect"
 	"testing"
 	"time"
@@ -41,6 +47,7 @@ func TestUpdateWithTimeout(t *testing.T) {
 		{name: "eternity panics", update: eternity, timeout: maxUpdateTime, deadman: true, err: nil, panic: true},
 		{name: "errors are propagated", update: ohmygosh, timeout: maxUpdateTime, deadman: true, err: &reflect.ValueError{}, panic: false},
 		{name: "errors are propagated even on timeout", update: eternity, timeout: maxUpdateTime, deadman: false, err: &cache.TimeoutError{}, panic: false},
+		{name: "panics are recovered", update: kaboom, timeout: maxUpdateTime, deadman: true, err: &cache.PanicError{}, panic: false},
 	}
 
 	for _, tt := range testTable {
@@ -61,13 +68,95 @@ func TestUpdateWithTimeout(t *testing.T) {
 			}
 
 			// Check that the cache is updated on error.
-			if err != nil && string(cache.FormatError(err)) != string(c.Get()) {
-				t.Errorf("Cache observed %s, expected %s", c.Get(), cache.FormatError(err))
+			if err != nil {
+				var observed cache.ErrorReport
+				if err := json.Unmarshal(c.Get(), &observed); err != nil {
+					t.Fatalf("Failed to unmarshal error report %s: %v", c.Get(), err)
+				}
+				if observed.Err != err.Error() || observed.Kind != cache.Classify(err) {
+					t.Errorf("Cache observed %s, expected %s", c.Get(), cache.FormatError(err))
+				}
+				if c.Snapshot().Err == nil {
+					t.Errorf("Snapshot error observed nil, expected %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestFormatError tests that error reports are valid JSON no matter what the
+// error message contains, and that errors are classified.
+func TestFormatError(t *testing.T) {
+	var testTable = []struct {
+		name   string
+		err    error
+		kind   cache.ErrorKind
+		status int
+	}{
+		{name: "timeouts", err: &cache.TimeoutError{}, kind: cache.KindTimeout, status: http.StatusGatewayTimeout},
+		{name: "missing executables", err: &exec.Error{Name: "sensors", Err: exec.ErrNotFound}, kind: cache.KindExecNotFound, status: http.StatusServiceUnavailable},
+		{name: "start failures", err: &cache.StartError{Err: fs.ErrNotExist}, kind: cache.KindExecNotFound, status: http.StatusServiceUnavailable},
+		{name: "missing fixtures", err: &cache.FixtureError{Path: "testdata/sensors.json", Err: fs.ErrNotExist}, kind: cache.KindFixture, status: http.StatusInternalServerError},
+		{name: "sysfs errors", err: &cache.SysfsError{Err: fs.ErrNotExist}, kind: cache.KindSysfs, status: http.StatusBadGateway},
+		{name: "non-zero exits", err: fmt.Errorf("sensors: %w", &exec.ExitError{}), kind: cache.KindNonZeroExit, status: http.StatusBadGateway},
+		{name: "output limits", err: &cache.CommandError{Command: "sensors", ExitCode: -1, Signal: 9, Err: cache.ErrOutputLimit}, kind: cache.KindOutputLimit, status: http.StatusBadGateway},
+		{name: "parse errors", err: &cache.ParseError{Err: errors.New("no chips")}, kind: cache.KindParse, status: http.StatusBadGateway},
+		{name: "panics", err: &cache.PanicError{Value: "oops"}, kind: cache.KindPanic, status: http.StatusInternalServerError},
+		{name: "quotes, backslashes and newlines", err: errors.New("\"sensors\" said:\nNo sensors found!\\"), kind: cache.KindUnknown, status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			var observed cache.ErrorReport
+			if err := json.Unmarshal(cache.FormatError(tt.err), &observed); err != nil {
+				t.Fatalf("Failed to unmarshal error report %s: %v", cache.FormatError(tt.err), err)
+			}
+
+			if observed.Err != tt.err.Error() {
+				t.Errorf("Message observed %q, expected %q", observed.Err, tt.err.Error())
+			}
+			if observed.Kind != tt.kind {
+				t.Errorf("Kind observed %s, expected %s", observed.Kind, tt.kind)
+			}
+			if observed.StatusCode() != tt.status {
+				t.Errorf("Status observed %d, expected %d", observed.StatusCode(), tt.status)
 			}
 		})
 	}
 }
 
+// TestStatus tests that status reports monitor metadata and usage.
+func TestStatus(t *testing.T) {
+	c := cache.NewCache("status", ohmygosh, maxUpdateTime)
+	c.Metadata = func() map[string]string { return map[string]string{"path": "/bin/sensors"} }
+	c.Usage = func() cache.Usage { return cache.Usage{Runs: 42} }
+	_ = c.UpdateWithTimeout(false)
+
+	status := c.Status()
+	if status.Name != "status" || status.Interval != maxUpdateTime {
+		t.Errorf("Status observed %+v, expected name status and interval %d", status, maxUpdateTime)
+	}
+	if status.Metadata["path"] != "/bin/sensors" {
+		t.Errorf("Metadata observed %v, expected path /bin/sensors", status.Metadata)
+	}
+	if status.Usage == nil || status.Usage.Runs != 42 {
+		t.Errorf("Usage observed %v, expected 42 runs", status.Usage)
+	}
+	if status.Err == nil {
+		t.Errorf("Error observed nil, expected %v", &reflect.ValueError{})
+	}
+	if status.Updates != 1 || status.Failures != 1 || status.Updated.IsZero() {
+		t.Errorf("Status observed %+v, expected one failed update", status)
+	}
+
+	c = cache.NewCache("status", instantaneous, maxUpdateTime)
+	_ = c.UpdateWithTimeout(false)
+	_ = c.UpdateWithTimeout(false)
+	if status := c.Status(); status.Updates != 2 || status.Failures != 0 {
+		t.Errorf("Status observed %+v, expected two successful updates", status)
+	}
+}
+
 func instantaneous() ([]byte, error) {
 	return nil, nil
 }
@@ -90,3 +179,7 @@ func amoment() ([]byte, error) {
 func ohmygosh() ([]byte, error) {
 	return nil, &reflect.ValueError{}
 }
+
+func kaboom() ([]byte, error) {
+	panic("kaboom")
+}
@@ -15,47 +15,515 @@
 package cache
 
 import (
-	"context"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
+const (
+	maxStderr = 4096        // Number of bytes of stderr kept in a CommandError
+	killGrace = time.Second // Default time between SIGTERM and SIGKILL on timeout
+
+	prSetChildSubreaper = 36 // PR_SET_CHILD_SUBREAPER from linux/prctl.h
+)
+
+// subreaper makes gosense a child subreaper once, see becomeSubreaper.
+var subreaper sync.Once
+
+// children holds the pids of the processes started by startGroup which have
+// not been waited for, so that reapOrphans leaves them to their Cmd. It is
+// locked while a process is started, so that one which exits straight away
+// is not mistaken for an orphan before its pid is added.
+var children = struct {
+	sync.Mutex
+	pids map[int]bool
+}{pids: make(map[int]bool)}
+
 // Command encapsulates a command to run.
 type Command struct {
-	Command string   // Name of command (relative or absolute)
-	Args    []string // Slice of arguments for command
-	Timeout int      // Number of seconds before process times out
+	Command     string              // Name of command (relative or absolute)
+	Args        []string            // Slice of arguments for command
+	Timeout     int                 // Number of seconds before process times out
+	KillGrace   time.Duration       // Time between SIGTERM and SIGKILL on timeout, zero means killGrace
+	Limits      Limits              // Resource limits applied before the command runs
+	MaxOutput   int                 // Maximum number of bytes read from stdout, 0 is unlimited
+	Env         []string            // Additional environment variables of the form "KEY=value"
+	Dir         string              // Working directory, "" is our working directory
+	CleanEnv    bool                // Start from LC_ALL=C and a minimal PATH instead of our environment
+	Pin         bool                // Resolve the executable once, refusing world writable ones
+	SHA256      string              // Expected hex SHA-256 digest of the executable, "" skips verification
+	Credential  *syscall.Credential // Run the command as another user, nil runs it as us
+	AmbientCaps []uintptr           // Capabilities (e.g. CapSysRawio) kept when running with Credential
+	Accounting  *Accounting         // Accumulates the resource usage of the command, if set
+}
+
+// ErrOutputLimit is wrapped by the CommandError returned when a command writes
+// more than Command.MaxOutput bytes to stdout. The command is killed.
+var ErrOutputLimit = errors.New("cache: command output exceeded limit")
+
+// CommandError is returned by RunCommand when a command could not be run or
+// did not exit successfully. It wraps the underlying error, so for example
+// errors.Is(err, exec.ErrNotFound) tells a missing binary from a failed one.
+type CommandError struct {
+	Command  string         // Command is the name of the command
+	Args     []string       // Args are the arguments passed to the command
+	ExitCode int            // ExitCode is the exit code, or -1 if the process did not exit
+	Signal   syscall.Signal // Signal is the signal which terminated the process, if any
+	Stderr   []byte         // Stderr is (at most maxStderr bytes of) the command's stderr
+	Wall     time.Duration  // Wall is the elapsed real time
+	CPU      time.Duration  // CPU is the user and system time used by the process
+	Usage    Usage          // Usage describes the resources used by the process
+	TimedOut bool           // TimedOut is true if the process was killed for taking too long
+	Err      error          // Err is the underlying error
+}
+
+// Error returns the string representing the error.
+func (e *CommandError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "command %s", e.Command)
+	var startErr *StartError
+	switch {
+	case e.TimedOut:
+		fmt.Fprintf(&b, " timed out after %v", e.Wall)
+	case errors.As(e.Err, &startErr):
+		fmt.Fprintf(&b, " failed: %v", e.Err)
+	case e.Signal != 0:
+		fmt.Fprintf(&b, " killed by signal %v", e.Signal)
+	default:
+		fmt.Fprintf(&b, " failed: %v", e.Err)
+	}
+	if stderr := bytes.TrimSpace(e.Stderr); len(stderr) > 0 {
+		fmt.Fprintf(&b, ": %s", stderr)
+	}
+
+	return b.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *CommandError) Unwrap() error {
+	return e.Err
 }
 
 // RunCommand forks a process to run Command returns its output with err (if
-// any). If the process times out it will be killed.
+// any). The process is started in its own process group. If it times out the
+// whole group is sent SIGTERM, then SIGKILL after a grace period, so children
+// of the command do not outlive it. Errors are always of type *CommandError.
 func RunCommand(command Command) ([]byte, error) {
-	absPath, err := exec.LookPath(command.Command)
+	var stdout bytes.Buffer
+	if err := runCommand(command, &stdout); err != nil {
+		return []byte(nil), err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// StreamCommand is like RunCommand but instead of buffering stdout it calls
+// line for each line of output as it is read, without the trailing newline.
+// The slice passed to line is only valid until it returns. If line returns an
+// error the command is killed and the error is returned wrapped in a
+// *CommandError.
+func StreamCommand(command Command, line func([]byte) error) error {
+	w := &lineWriter{line: line}
+	if err := runCommand(command, w); err != nil {
+		return err
+	}
+
+	return w.flush()
+}
+
+// runCommand runs command, copying its stdout to w.
+func runCommand(command Command, w io.Writer) error {
+	cmdErr := &CommandError{
+		Command:  command.Command,
+		Args:     command.Args,
+		ExitCode: -1,
+	}
+
+	mode, dir := fixtureMode()
+	if mode == FixtureReplay {
+		output, replayErr := replayFixture(dir, command)
+		if _, err := w.Write(output); err != nil {
+			cmdErr.Err = err
+			return cmdErr
+		}
+		if replayErr != nil {
+			return replayErr
+		}
+		return nil
+	}
+
+	var recorded bytes.Buffer
+	if mode == FixtureRecord {
+		w = io.MultiWriter(w, &recorded)
+	}
+
+	cmd, err := command.newCmd()
 	if err != nil {
-		log.Printf("didn't find %s executable", command.Command)
-		return nil, err
+		cmdErr.Err = err
+		return cmdErr
 	}
 
-	// We only use context for the timeout and kill process functionality...
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(command.Timeout)*time.Second)
-	defer cancel()
+	stdout := &stopWriter{w: w, limit: command.MaxOutput, stop: make(chan struct{})}
+	stderr := &limitedBuffer{limit: maxStderr}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
-	cmd := exec.CommandContext(ctx, absPath, command.Args...)
-	output, err := cmd.Output()
+	start := time.Now()
+	timedOut, err := runGroup(cmd, command.Limits, time.Duration(command.Timeout)*time.Second, command.grace(), stdout.stop)
+	cmdErr.Wall = time.Since(start)
+	cmdErr.Stderr = stderr.Bytes()
+	cmdErr.setState(cmd.ProcessState, command.Accounting)
+	if mode == FixtureRecord && cmd.ProcessState != nil {
+		if err := recordFixture(dir, command, recorded.Bytes(), cmdErr, timedOut); err != nil {
+			log.Printf("Failed to record %s, err %v\n", command.Command, err)
+		}
+	}
 
-	// The error returned by cmd.Output() will be OS specific based on what
+	// The error returned by cmd.Wait() will be OS specific based on what
 	// happens when a process is killed.
-	if ctx.Err() == context.DeadlineExceeded {
+	if timedOut {
 		log.Printf("Command %s timed out\n", command.Command)
-		return []byte(nil), ctx.Err()
+		cmdErr.TimedOut = true
+		cmdErr.Err = &TimeoutError{}
+		return cmdErr
+	}
+
+	// If we stopped reading, the command was killed and err is meaningless.
+	if stdout.err != nil {
+		log.Printf("Command %s killed, err %v\n", command.Command, stdout.err)
+		cmdErr.Err = stdout.err
+		return cmdErr
 	}
 
-	// If there's no context error, we know the command completed (or errored).
+	// Otherwise we know the command completed (or errored).
 	if err != nil {
-		log.Printf("Command %s returned non-zero, err %v, output %v\n", command.Command, err, output)
-		return []byte(nil), err
+		log.Printf("Command %s returned non-zero, err %v, stderr %q\n", command.Command, err, cmdErr.Stderr)
+		cmdErr.Err = err
+		return cmdErr
+	}
+
+	return nil
+}
+
+// newCmd resolves the executable and returns a Cmd to run it in its own
+// process group, with its environment and credentials.
+func (command Command) newCmd() (*exec.Cmd, error) {
+	exe, err := Resolve(command)
+	if err != nil {
+		log.Printf("didn't resolve %s executable, err %v", command.Command, err)
+		return nil, err
+	}
+
+	cmd := exec.Command(exe.Path, command.Args...)
+	cmd.Env = environ(command)
+	cmd.Dir = command.Dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:     true,
+		Credential:  command.Credential,
+		AmbientCaps: command.AmbientCaps,
+	}
+
+	return cmd, nil
+}
+
+// grace returns the time between SIGTERM and SIGKILL.
+func (command Command) grace() time.Duration {
+	if command.KillGrace == 0 {
+		return killGrace
+	}
+
+	return command.KillGrace
+}
+
+// setState records how the process exited and what it used, adding the usage
+// to accounting if it is not nil.
+func (e *CommandError) setState(state *os.ProcessState, accounting *Accounting) {
+	if state == nil {
+		return
+	}
+
+	e.ExitCode = state.ExitCode()
+	e.CPU = state.UserTime() + state.SystemTime()
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		e.Usage = newUsage(rusage)
+		if accounting != nil {
+			accounting.Add(e.Usage)
+		}
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		e.Signal = status.Signal()
+	}
+}
+
+// startGroup starts cmd, which must be configured to run in its own process
+// group, and applies limits to it before it runs any of its own code. If the
+// limits can not be applied the command is killed.
+func startGroup(cmd *exec.Cmd, limits Limits) error {
+	becomeSubreaper()
+	children.Lock()
+	defer children.Unlock()
+	if limits == (Limits{}) {
+		if err := cmd.Start(); err != nil {
+			return &StartError{Err: err}
+		}
+		children.pids[cmd.Process.Pid] = true
+		return nil
+	}
+
+	// The thread starting a traced process is its tracer until release
+	// detaches from it.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	cmd.SysProcAttr.Ptrace = true
+	if err := cmd.Start(); err != nil {
+		return &StartError{Err: err}
+	}
+	if err := limits.release(cmd.Process.Pid); err != nil {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		_ = cmd.Wait()
+		return &StartError{Err: err}
+	}
+	children.pids[cmd.Process.Pid] = true
+
+	return nil
+}
+
+// waitGroup waits for cmd, which was started by startGroup.
+func waitGroup(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	children.Lock()
+	delete(children.pids, cmd.Process.Pid)
+	children.Unlock()
+
+	return err
+}
+
+// runGroup starts cmd with startGroup and waits for it. If it runs longer than
+// timeout the group is sent SIGTERM, then SIGKILL after grace. Whatever is
+// left of the group is killed and reaped once the command exits, since
+// nothing a monitor starts should outlive it. If stop is closed the group is
+// killed immediately. It returns true if the command timed out.
+func runGroup(cmd *exec.Cmd, limits Limits, timeout, grace time.Duration, stop <-chan struct{}) (bool, error) {
+	if err := startGroup(cmd, limits); err != nil {
+		return false, err
+	}
+
+	pgid := cmd.Process.Pid
+	defer reapGroup(pgid, grace)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- waitGroup(cmd)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return false, err
+	case <-stop:
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+		return false, <-done
+	case <-timer.C:
+	}
+
+	return true, killGroup(pgid, grace, done)
+}
+
+// becomeSubreaper makes gosense the parent of its orphaned descendants instead
+// of init, so that reapGroup can wait for grandchildren whose parent was
+// killed. Without it they would be left as zombies when gosense runs as PID 1,
+// e.g. in a container, since nothing else reaps them there.
+func becomeSubreaper() {
+	subreaper.Do(func() {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+			log.Printf("cache: failed to become a child subreaper: %v", errno)
+		}
+	})
+}
+
+// reapGroup kills whatever is left of the process group pgid, whose leader has
+// already been waited for, and waits for the members orphaned to gosense until
+// the group is gone. Members which still have another living parent are left
+// to it, so it gives up after timeout. Descendants which left the group, e.g.
+// with setsid, are reaped by reapOrphans once they exit.
+func reapGroup(pgid int, timeout time.Duration) {
+	defer reapOrphans()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-pgid, &status, syscall.WNOHANG, nil)
+		if pid > 0 || err == syscall.EINTR {
+			continue
+		}
+		if syscall.Kill(-pgid, 0) == syscall.ESRCH || time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// reapOrphans waits for the exited children of gosense which were not started
+// by startGroup. As a subreaper gosense inherits every orphaned descendant of
+// the commands it runs, including those which left their process group, and
+// nothing else would reap them. Children started by startGroup are left to
+// their Cmd, so every process gosense starts must be started by startGroup.
+func reapOrphans() {
+	children.Lock()
+	defer children.Unlock()
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return
+	}
+	self := os.Getpid()
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || children.pids[pid] {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join("/proc", e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// The command name may contain spaces and parentheses, so the fields
+		// are those after the last parenthesis: state, then ppid.
+		fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+		if len(fields) < 2 || fields[0] != "Z" || fields[1] != strconv.Itoa(self) {
+			continue
+		}
+		var status syscall.WaitStatus
+		_, _ = syscall.Wait4(pid, &status, syscall.WNOHANG, nil)
+	}
+}
+
+// killGroup sends SIGTERM to the process group pgid, then SIGKILL if done has
+// not delivered the result of waiting for the group leader within grace. It
+// returns the result of waiting.
+func killGroup(pgid int, grace time.Duration, done <-chan error) error {
+	_ = syscall.Kill(-pgid, syscall.SIGTERM)
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(grace):
+	}
+
+	_ = syscall.Kill(-pgid, syscall.SIGKILL)
+	return <-done
+}
+
+// limitedBuffer is an io.Writer which keeps the first limit bytes written to
+// it and silently discards the rest, so a chatty process can neither block
+// on a full pipe nor grow our memory without bound.
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+// Write appends as much of p as fits. It always reports success.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+
+	return len(p), nil
+}
+
+// Bytes returns the bytes kept so far.
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// stopWriter passes writes through to w until w fails or more than limit
+// bytes have been written (if limit is positive). It then closes stop and
+// fails all further writes, which stops os/exec from reading the pipe.
+type stopWriter struct {
+	w     io.Writer
+	limit int
+	n     int
+	err   error
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// Write writes p to the underlying writer.
+func (s *stopWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	s.n += len(p)
+	if s.limit > 0 && s.n > s.limit {
+		return 0, s.fail(ErrOutputLimit)
+	}
+
+	n, err := s.w.Write(p)
+	if err != nil {
+		return n, s.fail(err)
+	}
+
+	return n, nil
+}
+
+// fail records err and closes stop.
+func (s *stopWriter) fail(err error) error {
+	s.err = err
+	s.once.Do(func() { close(s.stop) })
+	return err
+}
+
+// lineWriter splits writes into lines which are passed to line.
+type lineWriter struct {
+	line    func([]byte) error
+	partial []byte
+}
+
+// Write calls line for each complete line in p, keeping any remainder for
+// the next write.
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+
+	start := 0
+	for {
+		i := bytes.IndexByte(l.partial[start:], '\n')
+		if i < 0 {
+			break
+		}
+		if err := l.line(l.partial[start : start+i]); err != nil {
+			return 0, err
+		}
+		start += i + 1
+	}
+
+	// Move the remainder to the front rather than growing the buffer forever.
+	l.partial = l.partial[:copy(l.partial, l.partial[start:])]
+	return len(p), nil
+}
+
+// flush passes any final line which was not newline terminated to line.
+func (l *lineWriter) flush() error {
+	if len(l.partial) == 0 {
+		return nil
 	}
 
-	return output, nil
+	return l.line(l.partial)
 }
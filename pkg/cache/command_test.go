@@ -15,10 +15,18 @@
 package cache_test
 
 import (
-	"context"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"experimental/dwat/gosense/pkg/cache"
 )
@@ -34,8 +42,8 @@ func TestRunCommand(t *testing.T) {
 	}{
 		{name: "relative paths work", command: "ls", args: nil, timeout: 1, err: nil},
 		{name: "absolute paths work", command: "/bin/ls", args: nil, timeout: 1, err: nil},
-		{name: "errors are propagated", command: "false", args: nil, timeout: 1, err: &exec.ExitError{}},
-		{name: "timeouts kill", command: "sleep", args: []string{"1"}, timeout: 0, err: context.DeadlineExceeded},
+		{name: "errors are propagated", command: "false", args: nil, timeout: 1, err: &cache.CommandError{}},
+		{name: "timeouts kill", command: "sleep", args: []string{"1"}, timeout: 0, err: &cache.CommandError{}},
 	}
 
 	for _, tt := range testsTable {
@@ -52,3 +60,414 @@ func TestRunCommand(t *testing.T) {
 		})
 	}
 }
+
+// TestCommandError tests that failures are described in detail.
+func TestCommandError(t *testing.T) {
+	var testsTable = []struct {
+		name     string
+		command  string
+		args     []string
+		timeout  int
+		exitCode int
+		signal   syscall.Signal
+		stderr   []byte
+		timedOut bool
+		notFound bool
+	}{
+		{name: "missing executables are not found", command: "gosense-does-not-exist", timeout: 1, exitCode: -1, notFound: true},
+		{name: "exit codes and stderr are captured", command: "sh", args: []string{"-c", "echo 'No sensors found!' >&2; exit 3"}, timeout: 1, exitCode: 3, stderr: []byte("No sensors found!\n")},
+		{name: "signals are captured", command: "sh", args: []string{"-c", "kill -TERM $$"}, timeout: 1, exitCode: -1, signal: syscall.SIGTERM},
+		{name: "stderr is limited", command: "sh", args: []string{"-c", "head -c 100000 /dev/zero >&2; exit 1"}, timeout: 1, exitCode: 1, stderr: make([]byte, 4096)},
+		{name: "timeouts are flagged", command: "sleep", args: []string{"1"}, timeout: 0, exitCode: -1, signal: syscall.SIGTERM, timedOut: true},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cache.RunCommand(cache.Command{
+				Command: tt.command,
+				Args:    tt.args,
+				Timeout: tt.timeout,
+			})
+
+			var cmdErr *cache.CommandError
+			if !errors.As(err, &cmdErr) {
+				t.Fatalf("Error observed %T, expected *cache.CommandError", err)
+			}
+
+			if cmdErr.Command != tt.command || !reflect.DeepEqual(cmdErr.Args, tt.args) {
+				t.Errorf("Command observed %s %v, expected %s %v", cmdErr.Command, cmdErr.Args, tt.command, tt.args)
+			}
+			if cmdErr.ExitCode != tt.exitCode {
+				t.Errorf("Exit code observed %d, expected %d", cmdErr.ExitCode, tt.exitCode)
+			}
+			if cmdErr.Signal != tt.signal {
+				t.Errorf("Signal observed %v, expected %v", cmdErr.Signal, tt.signal)
+			}
+			if !bytes.Equal(cmdErr.Stderr, tt.stderr) {
+				t.Errorf("Stderr observed %d bytes %q, expected %d bytes %q", len(cmdErr.Stderr), cmdErr.Stderr, len(tt.stderr), tt.stderr)
+			}
+			if cmdErr.TimedOut != tt.timedOut {
+				t.Errorf("Timed out observed %t, expected %t", cmdErr.TimedOut, tt.timedOut)
+			}
+			if errors.Is(err, exec.ErrNotFound) != tt.notFound {
+				t.Errorf("Not found observed %t, expected %t", errors.Is(err, exec.ErrNotFound), tt.notFound)
+			}
+			if !tt.notFound && cmdErr.Wall <= 0 {
+				t.Errorf("Wall time observed %v, expected > 0", cmdErr.Wall)
+			}
+		})
+	}
+}
+
+// TestRunCommandKillsProcessGroup tests that grandchildren do not survive a
+// timeout, even if they ignore SIGTERM or were orphaned, and that they are
+// reaped rather than left as zombies.
+func TestRunCommandKillsProcessGroup(t *testing.T) {
+	var testsTable = []struct {
+		name   string
+		script string
+	}{
+		{name: "grandchildren are terminated", script: "sleep 30 & echo $! > %s; wait"},
+		{name: "grandchildren ignoring SIGTERM are killed", script: "trap '' TERM; sleep 30 & echo $! > %s; wait"},
+		{name: "orphaned grandchildren are reaped", script: "(sleep 30 & echo $! > %s); sleep 30"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			pidFile := filepath.Join(t.TempDir(), "pid")
+
+			start := time.Now()
+			_, err := cache.RunCommand(cache.Command{
+				Command:   "sh",
+				Args:      []string{"-c", fmt.Sprintf(tt.script, pidFile)},
+				Timeout:   1,
+				KillGrace: 100 * time.Millisecond,
+			})
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("RunCommand took %v, expected about 1s", elapsed)
+			}
+
+			var cmdErr *cache.CommandError
+			if !errors.As(err, &cmdErr) || !cmdErr.TimedOut {
+				t.Fatalf("Error observed %v, expected timeout", err)
+			}
+
+			data, err := os.ReadFile(pidFile)
+			if err != nil {
+				t.Fatalf("Failed to read grandchild pid: %v", err)
+			}
+			pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				t.Fatalf("Failed to parse grandchild pid %q: %v", data, err)
+			}
+
+			// The grandchild is orphaned to the test, which RunCommand made a
+			// subreaper, so it must be gone entirely once RunCommand returns.
+			if exists(pid) {
+				syscall.Kill(pid, syscall.SIGKILL)
+				t.Fatalf("Grandchild %d survived the timeout", pid)
+			}
+		})
+	}
+}
+
+// TestRunCommandReapsOrphans tests that a grandchild which left the process
+// group, and so survives it, is reaped once it exits rather than left as a
+// zombie of the subreaper.
+func TestRunCommandReapsOrphans(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	_, err := cache.RunCommand(cache.Command{
+		Command: "sh",
+		Args:    []string{"-c", fmt.Sprintf("setsid -f sh -c 'echo $$ > %s; sleep 0.2' >/dev/null 2>&1", pidFile)},
+		Timeout: 5,
+	})
+	if err != nil {
+		t.Fatalf("RunCommand failed %v", err)
+	}
+
+	var pid int
+	for deadline := time.Now().Add(5 * time.Second); pid == 0 || !zombie(pid); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Grandchild %d did not exit", pid)
+		}
+		if data, err := os.ReadFile(pidFile); err == nil {
+			pid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+		}
+	}
+
+	// The next command reaps it.
+	if _, err := cache.RunCommand(cache.Command{Command: "true", Timeout: 5}); err != nil {
+		t.Fatalf("RunCommand failed %v", err)
+	}
+	if exists(pid) {
+		t.Errorf("Grandchild %d was left a zombie", pid)
+	}
+}
+
+// zombie returns true if pid has exited but has not been waited for.
+func zombie(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+// exists returns true if pid exists, even as a zombie.
+func exists(pid int) bool {
+	_, err := os.Stat(fmt.Sprintf("/proc/%d", pid))
+	return err == nil
+}
+
+// TestRunCommandLimits tests that limits are applied to the command.
+func TestRunCommandLimits(t *testing.T) {
+	var testsTable = []struct {
+		name   string
+		script string
+		limits cache.Limits
+		output string
+	}{
+		{name: "nice", script: "nice", limits: cache.Limits{Nice: 5}, output: "5\n"},
+		{name: "cpu seconds", script: "ulimit -t", limits: cache.Limits{CPUSeconds: 10}, output: "10\n"},
+		{name: "address space", script: "ulimit -v", limits: cache.Limits{AddressSpace: 1 << 30}, output: "1048576\n"},
+		{name: "open files", script: "ulimit -n", limits: cache.Limits{OpenFiles: 64}, output: "64\n"},
+		{name: "io class", script: "ionice", limits: cache.Limits{IOClass: cache.IOClassIdle}, output: "idle\n"},
+		{name: "combined", script: "nice; ulimit -n", limits: cache.Limits{Nice: 10, OpenFiles: 32, IOClass: cache.IOClassBestEffort, IOLevel: 7}, output: "10\n32\n"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := cache.RunCommand(cache.Command{
+				Command: "sh",
+				Args:    []string{"-c", tt.script},
+				Timeout: 1,
+				Limits:  tt.limits,
+			})
+			if err != nil {
+				t.Fatalf("Error observed %v, expected nil", err)
+			}
+
+			if string(output) != tt.output {
+				t.Errorf("Output observed %q, expected %q", output, tt.output)
+			}
+		})
+	}
+}
+
+// TestRunCommandLimitsFail tests that commands whose limits can not be applied
+// are killed rather than left running without them.
+func TestRunCommandLimitsFail(t *testing.T) {
+	start := time.Now()
+	_, err := cache.RunCommand(cache.Command{
+		Command: "sleep",
+		Args:    []string{"30"},
+		Timeout: 5,
+		Limits:  cache.Limits{Cgroup: filepath.Join(t.TempDir(), "missing")},
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("RunCommand took %v, expected the command to be killed", elapsed)
+	}
+
+	var startErr *cache.StartError
+	if !errors.As(err, &startErr) || !strings.Contains(err.Error(), "cgroup") {
+		t.Errorf("Error observed %v, expected start failure", err)
+	}
+}
+
+// TestRunCommandMaxOutput tests that commands which write too much are killed.
+func TestRunCommandMaxOutput(t *testing.T) {
+	var testsTable = []struct {
+		name      string
+		script    string
+		maxOutput int
+		output    []byte
+		exceeded  bool
+	}{
+		{name: "unlimited", script: "echo hello", maxOutput: 0, output: []byte("hello\n")},
+		{name: "within limit", script: "echo hello", maxOutput: 6, output: []byte("hello\n")},
+		{name: "over limit", script: "echo hello", maxOutput: 5, exceeded: true},
+		{name: "endless output is killed", script: "yes", maxOutput: 1 << 16, exceeded: true},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := cache.RunCommand(cache.Command{
+				Command:   "sh",
+				Args:      []string{"-c", tt.script},
+				Timeout:   5,
+				MaxOutput: tt.maxOutput,
+			})
+
+			if errors.Is(err, cache.ErrOutputLimit) != tt.exceeded {
+				t.Fatalf("Error observed %v, expected exceeded %t", err, tt.exceeded)
+			}
+			if tt.exceeded && cache.Classify(err) != cache.KindOutputLimit {
+				t.Errorf("Kind observed %s, expected %s", cache.Classify(err), cache.KindOutputLimit)
+			}
+			var cmdErr *cache.CommandError
+			if errors.As(err, &cmdErr) && cmdErr.TimedOut {
+				t.Errorf("Command timed out, expected it to be killed")
+			}
+			if !bytes.Equal(output, tt.output) {
+				t.Errorf("Output observed %q, expected %q", output, tt.output)
+			}
+		})
+	}
+}
+
+// TestStreamCommand tests that output is passed to the callback line by line.
+func TestStreamCommand(t *testing.T) {
+	var lines []string
+	err := cache.StreamCommand(cache.Command{
+		Command: "printf",
+		Args:    []string{"one\ntwo\n\nthree"},
+		Timeout: 1,
+	}, func(line []byte) error {
+		lines = append(lines, string(line))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error observed %v, expected nil", err)
+	}
+
+	if expected := []string{"one", "two", "", "three"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Lines observed %q, expected %q", lines, expected)
+	}
+
+	// Errors from the callback stop the command.
+	stop := errors.New("seen enough")
+	err = cache.StreamCommand(cache.Command{
+		Command: "yes",
+		Timeout: 5,
+	}, func(line []byte) error {
+		return stop
+	})
+	var cmdErr *cache.CommandError
+	if !errors.Is(err, stop) || !errors.As(err, &cmdErr) || cmdErr.TimedOut {
+		t.Errorf("Error observed %v, expected %v", err, stop)
+	}
+}
+
+// TestRunCommandEnv tests that commands can be given a stable environment.
+func TestRunCommandEnv(t *testing.T) {
+	t.Setenv("LANG", "de_DE.UTF-8")
+	t.Setenv("GOSENSE_TEST", "inherited")
+	dir := t.TempDir()
+
+	var testsTable = []struct {
+		name    string
+		command string
+		args    []string
+		env     []string
+		dir     string
+		clean   bool
+		output  string
+	}{
+		{name: "environment is inherited", command: "sh", args: []string{"-c", "echo $LANG $GOSENSE_TEST"}, output: "de_DE.UTF-8 inherited\n"},
+		{name: "variables are added", command: "sh", args: []string{"-c", "echo $LANG $GOSENSE_TEST"}, env: []string{"GOSENSE_TEST=added"}, output: "de_DE.UTF-8 added\n"},
+		{name: "clean environments are clean", command: "sh", args: []string{"-c", "echo $LC_ALL $LANG $GOSENSE_TEST"}, clean: true, output: "C\n"},
+		{name: "clean environments can be added to", command: "sh", args: []string{"-c", "echo $LC_ALL $GOSENSE_TEST"}, env: []string{"LC_ALL=POSIX", "GOSENSE_TEST=added"}, clean: true, output: "POSIX added\n"},
+		{name: "clean paths are searched", command: "ls", args: []string{"-d", "."}, env: []string{"PATH=relative:/bin"}, clean: true, output: ".\n"},
+		{name: "working directories are set", command: "pwd", dir: dir, output: dir + "\n"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := cache.RunCommand(cache.Command{
+				Command:  tt.command,
+				Args:     tt.args,
+				Timeout:  1,
+				Env:      tt.env,
+				Dir:      tt.dir,
+				CleanEnv: tt.clean,
+			})
+			if err != nil {
+				t.Fatalf("Error observed %v, expected nil", err)
+			}
+
+			if string(output) != tt.output {
+				t.Errorf("Output observed %q, expected %q", output, tt.output)
+			}
+		})
+	}
+
+	// Commands are only found on the PATH they will run with.
+	_, err := cache.RunCommand(cache.Command{
+		Command:  "ls",
+		Timeout:  1,
+		Env:      []string{"PATH=" + dir},
+		CleanEnv: true,
+	})
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("Error observed %v, expected %v", err, exec.ErrNotFound)
+	}
+}
+
+// TestRunCommandCredential tests running commands as an unprivileged user
+// with only the capabilities they need.
+func TestRunCommandCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing credentials requires root")
+	}
+
+	var testsTable = []struct {
+		name        string
+		script      string
+		credential  *syscall.Credential
+		ambientCaps []uintptr
+		output      string
+	}{
+		{name: "users are changed", script: "id -u; id -g", credential: &syscall.Credential{Uid: 65534, Gid: 65534}, output: "65534\n65534\n"},
+		{name: "groups are changed", script: "id -G", credential: &syscall.Credential{Uid: 65534, Gid: 65534, Groups: []uint32{65533}}, output: "65534 65533\n"},
+		{name: "capabilities are kept", script: "grep CapAmb /proc/self/status", credential: &syscall.Credential{Uid: 65534, Gid: 65534}, ambientCaps: []uintptr{cache.CapSysRawio}, output: "CapAmb:\t0000000000020000\n"},
+		{name: "capabilities are dropped", script: "grep CapEff /proc/self/status", credential: &syscall.Credential{Uid: 65534, Gid: 65534}, output: "CapEff:\t0000000000000000\n"},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := cache.RunCommand(cache.Command{
+				Command:     "sh",
+				Args:        []string{"-c", tt.script},
+				Timeout:     1,
+				Credential:  tt.credential,
+				AmbientCaps: tt.ambientCaps,
+			})
+			if err != nil {
+				t.Fatalf("Error observed %v, expected nil", err)
+			}
+
+			if string(output) != tt.output {
+				t.Errorf("Output observed %q, expected %q", output, tt.output)
+			}
+		})
+	}
+}
+
+// TestRunCommandAccounting tests that resource usage is accumulated.
+func TestRunCommandAccounting(t *testing.T) {
+	var accounting cache.Accounting
+	command := cache.Command{
+		Command:    "sh",
+		Args:       []string{"-c", "i=0; while [ $i -lt 100000 ]; do i=$((i+1)); done"},
+		Timeout:    5,
+		Accounting: &accounting,
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.RunCommand(command); err != nil {
+			t.Fatalf("Error observed %v, expected nil", err)
+		}
+	}
+
+	total := accounting.Total()
+	if total.Runs != 2 {
+		t.Errorf("Runs observed %d, expected 2", total.Runs)
+	}
+	if total.UserTime+total.SystemTime <= 0 {
+		t.Errorf("CPU time observed %v, expected > 0", total.UserTime+total.SystemTime)
+	}
+	if total.MaxRSS <= 0 {
+		t.Errorf("Max RSS observed %d, expected > 0", total.MaxRSS)
+	}
+}
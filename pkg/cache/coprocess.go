@@ -0,0 +1,380 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrBadFrame is returned when a coprocess sends a malformed frame.
+var ErrBadFrame = errors.New("cache: malformed coprocess frame")
+
+// maxFrameHeader is the length of the longest frame header without its
+// newline, the digits of the largest int64.
+const maxFrameHeader = 19
+
+// Coprocess runs a long lived helper process for monitors which would
+// otherwise fork and exec a command on every update. Requests and responses
+// are exchanged as frames over the helper's stdin and stdout. A frame is a
+// decimal length and a newline followed by that many bytes. The helper must
+// answer each request frame with exactly one response frame, and must answer
+// an empty request (a ping) with an empty response.
+//
+// The helper is started on first use, and restarted on the next call after
+// it crashes or misbehaves. If PingInterval is set the helper is also pinged
+// between calls, so that a broken helper is replaced before the next update
+// needs it. Calls which take longer than Command.Timeout kill the helper's
+// process group in the same way as RunCommand. Calls are serialized, so a
+// Coprocess is safe for concurrent use.
+type Coprocess struct {
+	Command      Command       // Command starts the helper; Timeout and MaxOutput apply to each call
+	PingInterval time.Duration // PingInterval is the time between health checks, 0 disables them
+
+	mu       sync.Mutex
+	proc     *coprocess    // proc is the running helper, or nil
+	quit     chan struct{} // quit stops the health checks, or is nil if they are not running
+	started  int           // started is the number of times the helper has been started
+	restarts int           // restarts is the number of times the helper had to be replaced
+}
+
+// coprocess is one instance of a helper process.
+type coprocess struct {
+	cmd    *exec.Cmd
+	stdin  *os.File // stdin is our end of the helper's stdin
+	pipe   *os.File // pipe is our end of the helper's stdout, read through stdout
+	stdout *bufio.Reader
+	stderr *limitedBuffer
+	start  time.Time
+	done   chan struct{} // done is closed once the helper has been waited for
+	err    error         // err is the result of waiting, valid once done is closed
+}
+
+// NewCoprocess returns a Coprocess for command. The helper is not started
+// until the first call.
+func NewCoprocess(command Command) *Coprocess {
+	return &Coprocess{Command: command}
+}
+
+// Call sends request to the helper and returns its response. Errors are
+// always of type *CommandError, and mean the helper has been stopped.
+func (c *Coprocess) Call(request []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.call(request)
+}
+
+// call implements Call. It must be called with mu held.
+func (c *Coprocess) call(request []byte) ([]byte, error) {
+	if c.proc != nil && c.proc.exited() {
+		log.Printf("Coprocess %s exited, err %v, restarting\n", c.Command.Command, c.proc.err)
+		c.stop(c.proc.err, false)
+		c.restarts++
+	}
+	if c.proc == nil {
+		if err := c.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	type result struct {
+		response []byte
+		err      error
+	}
+	results := make(chan result, 1)
+	go func(p *coprocess) {
+		if err := WriteFrame(p.stdin, request); err != nil {
+			results <- result{err: err}
+			return
+		}
+		response, err := ReadFrame(p.stdout, c.Command.MaxOutput)
+		results <- result{response: response, err: err}
+	}(c.proc)
+
+	timer := time.NewTimer(time.Duration(c.Command.Timeout) * time.Second)
+	defer timer.Stop()
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			return nil, c.stop(r.err, false)
+		}
+		return r.response, nil
+	case <-timer.C:
+		log.Printf("Coprocess %s timed out\n", c.Command.Command)
+		return nil, c.stop(&TimeoutError{}, true)
+	}
+}
+
+// Ping checks that the helper is healthy, starting it if necessary.
+func (c *Coprocess) Ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ping()
+}
+
+// ping implements Ping. It must be called with mu held.
+func (c *Coprocess) ping() error {
+	response, err := c.call(nil)
+	if err != nil {
+		return err
+	}
+	if len(response) != 0 {
+		return c.stop(ErrBadFrame, false)
+	}
+
+	return nil
+}
+
+// healthCheck pings the helper every PingInterval until quit is closed.
+func (c *Coprocess) healthCheck(quit <-chan struct{}) {
+	ticker := time.NewTicker(c.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		select {
+		case <-quit:
+			// Close won the lock; do not start the helper again.
+		default:
+			if err := c.ping(); err != nil {
+				log.Printf("Coprocess %s failed health check, err %v\n", c.Command.Command, err)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Close stops the health checks and the helper. Closing the helper's stdin
+// asks it to exit; it is killed if it has not within Command.KillGrace. A later
+// call will start it again.
+func (c *Coprocess) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.quit != nil {
+		close(c.quit)
+		c.quit = nil
+	}
+	if c.proc == nil {
+		return nil
+	}
+
+	c.proc.stdin.Close()
+	select {
+	case <-c.proc.done:
+	case <-time.After(c.Command.grace()):
+	}
+	c.stop(nil, false)
+	return nil
+}
+
+// Metadata describes the helper, for use as a monitor's metadata.
+func (c *Coprocess) Metadata() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metadata := map[string]string{
+		"started":  strconv.Itoa(c.started),
+		"restarts": strconv.Itoa(c.restarts),
+	}
+	if c.proc != nil && !c.proc.exited() {
+		metadata["pid"] = strconv.Itoa(c.proc.cmd.Process.Pid)
+	}
+
+	return metadata
+}
+
+// start starts the helper. It must be called with mu held.
+func (c *Coprocess) start() error {
+	cmdErr := &CommandError{
+		Command:  c.Command.Command,
+		Args:     c.Command.Args,
+		ExitCode: -1,
+	}
+
+	cmd, err := c.Command.newCmd()
+	if err != nil {
+		cmdErr.Err = err
+		return cmdErr
+	}
+
+	p := &coprocess{
+		cmd:    cmd,
+		stderr: &limitedBuffer{limit: maxStderr},
+		done:   make(chan struct{}),
+	}
+	// The pipes are ours rather than cmd's, so that waiting for the helper
+	// does not close stdout while a call may still be reading it.
+	stdin, stdout, err := p.pipes()
+	if err != nil {
+		cmdErr.Err = err
+		return cmdErr
+	}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = p.stderr
+
+	p.start = time.Now()
+	err = startGroup(cmd, c.Command.Limits)
+	stdin.Close()
+	stdout.Close()
+	if err != nil {
+		p.close()
+		cmdErr.Err = err
+		return cmdErr
+	}
+	go func() {
+		p.err = waitGroup(cmd)
+		close(p.done)
+	}()
+
+	c.proc = p
+	c.started++
+	if c.PingInterval > 0 && c.quit == nil {
+		c.quit = make(chan struct{})
+		go c.healthCheck(c.quit)
+	}
+	return nil
+}
+
+// stop kills the helper's process group and waits for it. It returns a
+// CommandError describing why the helper was stopped, or nil if err is nil.
+// It must be called with mu held.
+func (c *Coprocess) stop(err error, timedOut bool) error {
+	p := c.proc
+	c.proc = nil
+
+	waited := make(chan error, 1)
+	go func() {
+		<-p.done
+		waited <- p.err
+	}()
+	_ = killGroup(p.cmd.Process.Pid, c.Command.grace(), waited)
+	reapGroup(p.cmd.Process.Pid, c.Command.grace())
+	p.close()
+
+	cmdErr := &CommandError{
+		Command:  c.Command.Command,
+		Args:     c.Command.Args,
+		ExitCode: -1,
+		Stderr:   p.stderr.Bytes(),
+		Wall:     time.Since(p.start),
+		TimedOut: timedOut,
+		Err:      err,
+	}
+	cmdErr.setState(p.cmd.ProcessState, c.Command.Accounting)
+	if err == nil {
+		return nil
+	}
+
+	return cmdErr
+}
+
+// pipes creates the helper's stdin and stdout. It keeps our ends in p and
+// returns the helper's, which the caller must close once the helper has
+// started.
+func (p *coprocess) pipes() (stdin, stdout *os.File, err error) {
+	if stdin, p.stdin, err = os.Pipe(); err != nil {
+		return nil, nil, err
+	}
+	if p.pipe, stdout, err = os.Pipe(); err != nil {
+		stdin.Close()
+		p.stdin.Close()
+		return nil, nil, err
+	}
+	p.stdout = bufio.NewReader(p.pipe)
+
+	return stdin, stdout, nil
+}
+
+// close closes our ends of the helper's pipes, which also interrupts a call
+// still reading from or writing to the helper.
+func (p *coprocess) close() {
+	p.stdin.Close()
+	p.pipe.Close()
+}
+
+// exited returns true if the helper has exited.
+func (p *coprocess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// WriteFrame writes payload to w as a frame.
+func WriteFrame(w io.Writer, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+21)
+	frame = strconv.AppendInt(frame, int64(len(payload)), 10)
+	frame = append(frame, '\n')
+	frame = append(frame, payload...)
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame reads a frame from r and returns its payload. If max is positive,
+// frames larger than max bytes are refused with ErrOutputLimit. Headers are
+// refused with ErrBadFrame once they are too long to hold a length, so that a
+// helper can not grow our memory by never sending a newline.
+func ReadFrame(r *bufio.Reader, max int) ([]byte, error) {
+	header := make([]byte, 0, maxFrameHeader)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '\n' {
+			break
+		}
+		if len(header) == maxFrameHeader {
+			return nil, fmt.Errorf("%w: header %q... has no newline", ErrBadFrame, header)
+		}
+		header = append(header, b)
+	}
+
+	n, err := strconv.Atoi(string(header))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%w: header %q", ErrBadFrame, header)
+	}
+	if max > 0 && n > max {
+		return nil, ErrOutputLimit
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
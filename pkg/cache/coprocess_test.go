@@ -0,0 +1,206 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"experimental/dwat/gosense/pkg/cache"
+)
+
+const (
+	helperEnv    = "GOSENSE_TEST_COPROCESS"
+	helperEOFEnv = "GOSENSE_TEST_COPROCESS_EOF" // File the helper creates when stdin is closed
+)
+
+// TestHelperProcess isn't a real test. It is the helper run by the coprocess
+// tests: it echoes requests in upper case, and crashes, hangs or writes a
+// header which never ends on request. It exits cleanly when stdin is closed.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(helperEnv) != "1" {
+		return
+	}
+
+	r := bufio.NewReader(os.Stdin)
+	for {
+		request, err := cache.ReadFrame(r, 0)
+		if err != nil {
+			if eof := os.Getenv(helperEOFEnv); eof != "" {
+				os.WriteFile(eof, nil, 0644)
+			}
+			os.Exit(0)
+		}
+
+		switch string(request) {
+		case "crash":
+			os.Exit(3)
+		case "hang":
+			select {}
+		case "garbage":
+			os.Stdout.Write([]byte("garbage\n"))
+		case "endless":
+			digits := bytes.Repeat([]byte("1"), 4096)
+			for {
+				os.Stdout.Write(digits)
+			}
+		case "pid":
+			cache.WriteFrame(os.Stdout, []byte(strconv.Itoa(os.Getpid())))
+		default:
+			cache.WriteFrame(os.Stdout, bytes.ToUpper(request))
+		}
+	}
+}
+
+// newHelper returns a Coprocess running TestHelperProcess with env.
+func newHelper(t *testing.T, env ...string) *cache.Coprocess {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Failed to find test executable: %v", err)
+	}
+
+	c := cache.NewCoprocess(cache.Command{
+		Command:   self,
+		Args:      []string{"-test.run=^TestHelperProcess$"},
+		Timeout:   1,
+		KillGrace: 100 * time.Millisecond,
+		Env:       append([]string{helperEnv + "=1"}, env...),
+	})
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// TestCoprocess tests that requests are answered by a single helper.
+func TestCoprocess(t *testing.T) {
+	c := newHelper(t)
+
+	if err := c.Ping(); err != nil {
+		t.Fatalf("Ping observed %v, expected nil", err)
+	}
+
+	for _, request := range []string{"hello", "", "multi\nline\n"} {
+		response, err := c.Call([]byte(request))
+		if err != nil {
+			t.Fatalf("Error observed %v, expected nil", err)
+		}
+		if expected := bytes.ToUpper([]byte(request)); !bytes.Equal(response, expected) {
+			t.Errorf("Response observed %q, expected %q", response, expected)
+		}
+	}
+
+	if metadata := c.Metadata(); metadata["started"] != "1" || metadata["restarts"] != "0" || metadata["pid"] == "" {
+		t.Errorf("Metadata observed %v, expected one running helper", metadata)
+	}
+}
+
+// TestCoprocessRestart tests that broken helpers are replaced.
+func TestCoprocessRestart(t *testing.T) {
+	var testsTable = []struct {
+		name     string
+		request  string
+		timedOut bool
+		exitCode int
+		err      error
+	}{
+		{name: "crashes are restarted", request: "crash", exitCode: 3},
+		{name: "hangs are killed", request: "hang", timedOut: true, exitCode: -1},
+		{name: "garbage is refused", request: "garbage", exitCode: -1, err: cache.ErrBadFrame},
+		{name: "endless headers are refused", request: "endless", exitCode: -1, err: cache.ErrBadFrame},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newHelper(t)
+
+			before, err := c.Call([]byte("pid"))
+			if err != nil {
+				t.Fatalf("Error observed %v, expected nil", err)
+			}
+
+			_, err = c.Call([]byte(tt.request))
+			var cmdErr *cache.CommandError
+			if !errors.As(err, &cmdErr) {
+				t.Fatalf("Error observed %v, expected *cache.CommandError", err)
+			}
+			if cmdErr.TimedOut != tt.timedOut || cmdErr.ExitCode != tt.exitCode {
+				t.Errorf("Error observed %+v, expected timed out %t and exit code %d", cmdErr, tt.timedOut, tt.exitCode)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("Error observed %v, expected %v", err, tt.err)
+			}
+
+			after, err := c.Call([]byte("pid"))
+			if err != nil {
+				t.Fatalf("Error observed %v, expected nil", err)
+			}
+			if bytes.Equal(before, after) {
+				t.Errorf("Helper pid observed %s after failure, expected a new helper", after)
+			}
+		})
+	}
+}
+
+// TestCoprocessClose tests that Close lets the helper exit on EOF instead of
+// killing it.
+func TestCoprocessClose(t *testing.T) {
+	eof := filepath.Join(t.TempDir(), "eof")
+	c := newHelper(t, helperEOFEnv+"="+eof)
+
+	if err := c.Ping(); err != nil {
+		t.Fatalf("Ping observed %v, expected nil", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close observed %v, expected nil", err)
+	}
+
+	if _, err := os.Stat(eof); err != nil {
+		t.Errorf("Helper did not exit on EOF: %v", err)
+	}
+}
+
+// TestCoprocessHealthCheck tests that a helper which dies between calls is
+// replaced by the health checks.
+func TestCoprocessHealthCheck(t *testing.T) {
+	c := newHelper(t)
+	c.PingInterval = 50 * time.Millisecond
+
+	response, err := c.Call([]byte("pid"))
+	if err != nil {
+		t.Fatalf("Error observed %v, expected nil", err)
+	}
+	pid, err := strconv.Atoi(string(response))
+	if err != nil {
+		t.Fatalf("Failed to parse helper pid %q: %v", response, err)
+	}
+	syscall.Kill(pid, syscall.SIGKILL)
+
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		metadata := c.Metadata()
+		if metadata["restarts"] == "1" && metadata["pid"] != "" && metadata["pid"] != string(response) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Metadata observed %v, expected the helper to be restarted", metadata)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Capabilities commonly needed by monitor commands, see capabilities(7).
+const (
+	CapDacOverride   = 1  // CAP_DAC_OVERRIDE
+	CapDacReadSearch = 2  // CAP_DAC_READ_SEARCH
+	CapNetAdmin      = 12 // CAP_NET_ADMIN
+	CapNetRaw        = 13 // CAP_NET_RAW
+	CapSysRawio      = 17 // CAP_SYS_RAWIO, e.g. for SMART
+	CapSysAdmin      = 21 // CAP_SYS_ADMIN
+)
+
+// LookupCredential returns the credential of the named user, including its
+// supplementary groups, for use as Command.Credential.
+func LookupCredential(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	groupIds, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groupIds {
+		group, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		credential.Groups = append(credential.Groups, uint32(group))
+	}
+
+	return credential, nil
+}
@@ -0,0 +1,170 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+)
+
+// ErrorKind classifies why an update failed so that clients can react without
+// parsing error strings.
+type ErrorKind string
+
+const (
+	KindTimeout      ErrorKind = "timeout"        // Update or command took too long
+	KindExecNotFound ErrorKind = "exec-not-found" // Command executable could not be found or started
+	KindFixture      ErrorKind = "fixture"        // Replay fixture could not be read
+	KindSysfs        ErrorKind = "sysfs"          // Sysfs attributes could not be read
+	KindNonZeroExit  ErrorKind = "non-zero-exit"  // Command ran but did not exit cleanly
+	KindOutputLimit  ErrorKind = "output-limit"   // Command wrote more than its MaxOutput
+	KindParse        ErrorKind = "parse"          // Output could not be parsed
+	KindPanic        ErrorKind = "panic"          // Update panicked
+	KindUnknown      ErrorKind = "unknown"        // Anything else
+)
+
+// Classify returns the kind of err. Wrapped errors are unwrapped, so monitors
+// may add context with fmt.Errorf("...: %w", err).
+func Classify(err error) ErrorKind {
+	var (
+		timeoutErr   *TimeoutError
+		panicErr     *PanicError
+		execErr      *exec.Error
+		startErr     *StartError
+		fixtureErr   *FixtureError
+		sysfsErr     *SysfsError
+		parseErr     *ParseError
+		exitErr      *exec.ExitError
+		cmdErr       *CommandError
+		syntaxErr    *json.SyntaxError
+		unmarshalErr *json.UnmarshalTypeError
+		numErr       *strconv.NumError
+	)
+
+	switch {
+	case errors.As(err, &timeoutErr), errors.Is(err, context.DeadlineExceeded):
+		return KindTimeout
+	case errors.As(err, &panicErr):
+		return KindPanic
+	case errors.As(err, &fixtureErr):
+		return KindFixture
+	case errors.As(err, &sysfsErr):
+		return KindSysfs
+	case errors.As(err, &execErr), errors.As(err, &startErr):
+		return KindExecNotFound
+	case errors.Is(err, ErrOutputLimit):
+		return KindOutputLimit
+	case errors.As(err, &exitErr), errors.As(err, &cmdErr) && cmdErr.ExitCode > 0:
+		return KindNonZeroExit
+	case errors.As(err, &parseErr), errors.As(err, &syntaxErr), errors.As(err, &unmarshalErr), errors.As(err, &numErr):
+		return KindParse
+	}
+
+	return KindUnknown
+}
+
+// StatusCode returns the HTTP status code used to serve an error of kind k.
+func (k ErrorKind) StatusCode() int {
+	switch k {
+	case KindTimeout:
+		return http.StatusGatewayTimeout
+	case KindExecNotFound:
+		return http.StatusServiceUnavailable
+	case KindNonZeroExit, KindOutputLimit, KindParse, KindSysfs:
+		return http.StatusBadGateway
+	}
+
+	return http.StatusInternalServerError
+}
+
+// PanicError is returned when an update panics. The cache recovers the panic
+// so that one broken monitor does not take down the shared http server.
+type PanicError struct {
+	Value interface{} // Value passed to panic
+	Stack []byte      // Stack of the panicking goroutine
+}
+
+// Error returns the string representing the error.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("cache: update panicked: %v", e.Value)
+}
+
+// ParseError may be returned by updates which fail to parse their data source.
+type ParseError struct {
+	Err error // Err is the underlying error
+}
+
+// Error returns the string representing the error.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("cache: parse error: %v", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// StartError is returned when a command was found but its process could not
+// be started, e.g. because the executable was removed after it was resolved.
+type StartError struct {
+	Err error // Err is the underlying error
+}
+
+// Error returns the string representing the error.
+func (e *StartError) Error() string {
+	return fmt.Sprintf("cache: start failed: %v", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// FixtureError is returned when a command is replayed from a fixture which is
+// missing or cannot be decoded. The command itself is never run.
+type FixtureError struct {
+	Path string // Path is the fixture file
+	Err  error  // Err is the underlying error
+}
+
+// Error returns the string representing the error.
+func (e *FixtureError) Error() string {
+	return fmt.Sprintf("cache: fixture %s: %v", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *FixtureError) Unwrap() error {
+	return e.Err
+}
+
+// SysfsError may be returned by updates which fail to read sysfs.
+type SysfsError struct {
+	Err error // Err is the underlying error
+}
+
+// Error returns the string representing the error.
+func (e *SysfsError) Error() string {
+	return fmt.Sprintf("cache: sysfs error: %v", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *SysfsError) Unwrap() error {
+	return e.Err
+}
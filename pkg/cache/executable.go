@@ -0,0 +1,164 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	// ErrInsecureExecutable is wrapped by errors resolving a pinned command
+	// whose executable, or a directory above it, is world writable.
+	ErrInsecureExecutable = errors.New("cache: executable is world writable")
+	// ErrDigestMismatch is wrapped by errors resolving a command whose
+	// executable does not have the expected SHA-256 digest.
+	ErrDigestMismatch = errors.New("cache: executable digest mismatch")
+	// ErrExecutableChanged is wrapped by errors running a pinned command whose
+	// executable has changed since it was resolved.
+	ErrExecutableChanged = errors.New("cache: executable changed since it was pinned")
+)
+
+// pins maps commands to the executables they were pinned to.
+var pins = struct {
+	sync.Mutex
+	executables map[string]*Executable
+}{executables: make(map[string]*Executable)}
+
+// Executable is a command resolved to an absolute path.
+type Executable struct {
+	Path   string      `json:"path"`   // Path is the absolute path of the executable
+	SHA256 string      `json:"sha256"` // SHA256 is the hex encoded digest of the executable
+	info   os.FileInfo // info identifies the file which was resolved
+}
+
+// Metadata returns a description of the executable suitable for a monitor's
+// metadata.
+func (e *Executable) Metadata() map[string]string {
+	return map[string]string{
+		"path":   e.Path,
+		"sha256": e.SHA256,
+	}
+}
+
+// Resolve returns the executable command runs. Commands with Pin set are
+// resolved once, checked, and then reused for the life of the process. The
+// executable of a pinned command must not be world writable, nor may any
+// directory above it. Commands with SHA256 set must match the digest.
+func Resolve(command Command) (*Executable, error) {
+	env := environ(command)
+	if !command.Pin {
+		path, err := lookPath(command.Command, env)
+		if err != nil {
+			return nil, err
+		}
+		if command.SHA256 == "" {
+			return &Executable{Path: path}, nil
+		}
+		return verify(path, command.SHA256)
+	}
+
+	key := fmt.Sprintf("%s\x00%s\x00%q", command.Command, command.SHA256, env)
+
+	pins.Lock()
+	defer pins.Unlock()
+
+	if exe, ok := pins.executables[key]; ok {
+		if info, err := os.Stat(exe.Path); err != nil || !os.SameFile(info, exe.info) ||
+			info.Size() != exe.info.Size() || !info.ModTime().Equal(exe.info.ModTime()) {
+			return nil, fmt.Errorf("%s: %w", exe.Path, ErrExecutableChanged)
+		}
+		return exe, nil
+	}
+
+	path, err := lookPath(command.Command, env)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWritable(path); err != nil {
+		return nil, err
+	}
+	exe, err := verify(path, command.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	pins.executables[key] = exe
+	return exe, nil
+}
+
+// verify digests the executable at path, and compares it with expected unless
+// expected is empty.
+func verify(path string, expected string) (*Executable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+	if expected != "" && digest != expected {
+		return nil, fmt.Errorf("%s has digest %s, expected %s: %w", path, digest, expected, ErrDigestMismatch)
+	}
+
+	return &Executable{Path: path, SHA256: digest, info: info}, nil
+}
+
+// checkWritable returns an error if path, after following symlinks, or any of
+// the directories above either path are world writable.
+func checkWritable(path string) error {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{target}
+	for _, p := range []string{path, target} {
+		for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+			paths = append(paths, dir)
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if info.Mode().Perm()&0002 != 0 {
+			return fmt.Errorf("%s: %w", p, ErrInsecureExecutable)
+		}
+	}
+
+	return nil
+}
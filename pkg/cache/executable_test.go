@@ -0,0 +1,86 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"experimental/dwat/gosense/pkg/cache"
+)
+
+// TestResolve tests resolving, pinning and verifying executables.
+func TestResolve(t *testing.T) {
+	truePath, err := exec.LookPath("true")
+	if err != nil {
+		t.Fatalf("Failed to find true: %v", err)
+	}
+	data, err := os.ReadFile(truePath)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", truePath, err)
+	}
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	// t.TempDir() lives under a world writable directory such as /tmp.
+	insecure := filepath.Join(t.TempDir(), "true")
+	if err := os.WriteFile(insecure, data, 0755); err != nil {
+		t.Fatalf("Failed to copy %s: %v", truePath, err)
+	}
+
+	var testsTable = []struct {
+		name    string
+		command cache.Command
+		path    string
+		digest  string
+		err     error
+	}{
+		{name: "pinned commands are resolved", command: cache.Command{Command: "true", Pin: true}, path: truePath, digest: digest},
+		{name: "pinned digests are verified", command: cache.Command{Command: "true", Pin: true, SHA256: digest}, path: truePath, digest: digest},
+		{name: "pinned digests must match", command: cache.Command{Command: "true", Pin: true, SHA256: "00"}, err: cache.ErrDigestMismatch},
+		{name: "unpinned digests must match", command: cache.Command{Command: "true", SHA256: "00"}, err: cache.ErrDigestMismatch},
+		{name: "unpinned commands are not checked", command: cache.Command{Command: insecure}, path: insecure},
+		{name: "world writable directories are refused", command: cache.Command{Command: insecure, Pin: true}, err: cache.ErrInsecureExecutable},
+		{name: "missing commands are not found", command: cache.Command{Command: "gosense-does-not-exist", Pin: true}, err: exec.ErrNotFound},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			exe, err := cache.Resolve(tt.command)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Error observed %v, expected %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if exe.Path != tt.path || exe.SHA256 != tt.digest {
+				t.Errorf("Executable observed %+v, expected %s %s", exe.Metadata(), tt.path, tt.digest)
+			}
+		})
+	}
+
+	// Pinned commands are resolved once.
+	first, _ := cache.Resolve(cache.Command{Command: "true", Pin: true})
+	second, _ := cache.Resolve(cache.Command{Command: "true", Pin: true})
+	if first == nil || first != second {
+		t.Errorf("Pinned executables observed %p and %p, expected the same", first, second)
+	}
+}
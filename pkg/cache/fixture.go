@@ -0,0 +1,150 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FixtureMode determines whether commands are recorded or replayed.
+type FixtureMode int
+
+const (
+	FixtureOff    FixtureMode = iota // Run commands normally
+	FixtureRecord                    // Run commands and save a fixture for each run
+	FixtureReplay                    // Serve commands from fixtures instead of running them
+)
+
+// fixtures holds the current FixtureMode and directory.
+var fixtures = struct {
+	sync.RWMutex
+	mode FixtureMode
+	dir  string
+}{}
+
+// unsafeName matches characters we do not want in fixture file names.
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9.+-]+`)
+
+// Fixture is a recorded run of a command. Output is stored as strings so that
+// fixtures are easy to read and edit by hand.
+type Fixture struct {
+	Command  string         `json:"command"`   // Command is the name of the command
+	Args     []string       `json:"args"`      // Args are the arguments passed to the command
+	Stdout   string         `json:"stdout"`    // Stdout is the command's output
+	Stderr   string         `json:"stderr"`    // Stderr is (at most maxStderr bytes of) the command's stderr
+	ExitCode int            `json:"exit_code"` // ExitCode is the exit code, or -1 if the process was killed
+	Signal   syscall.Signal `json:"signal"`    // Signal is the signal which terminated the process, if any
+	TimedOut bool           `json:"timed_out"` // TimedOut is true if the process was killed for taking too long
+	Wall     time.Duration  `json:"wall_ns"`   // Wall is the elapsed real time
+}
+
+// SetFixtures sets whether RunCommand and StreamCommand record fixtures to,
+// or replay fixtures from, dir. This allows monitors to be tested against
+// output captured from real hardware.
+func SetFixtures(mode FixtureMode, dir string) {
+	fixtures.Lock()
+	fixtures.mode = mode
+	fixtures.dir = dir
+	fixtures.Unlock()
+}
+
+// fixtureMode returns the current FixtureMode and directory.
+func fixtureMode() (FixtureMode, string) {
+	fixtures.RLock()
+	defer fixtures.RUnlock()
+	return fixtures.mode, fixtures.dir
+}
+
+// FixturePath returns the path of the fixture for command in dir. The name is
+// derived from the base name of the command and its arguments, e.g.
+// sensors_-u.json for "sensors -u".
+func FixturePath(dir string, command Command) string {
+	parts := append([]string{filepath.Base(command.Command)}, command.Args...)
+	name := unsafeName.ReplaceAllString(strings.Join(parts, "_"), "_")
+	return filepath.Join(dir, name+".json")
+}
+
+// recordFixture saves a fixture of a run of command, described by cmdErr.
+func recordFixture(dir string, command Command, stdout []byte, cmdErr *CommandError, timedOut bool) error {
+	fixture := Fixture{
+		Command:  command.Command,
+		Args:     command.Args,
+		Stdout:   string(stdout),
+		Stderr:   string(cmdErr.Stderr),
+		ExitCode: cmdErr.ExitCode,
+		Signal:   cmdErr.Signal,
+		TimedOut: timedOut,
+		Wall:     cmdErr.Wall,
+	}
+
+	encoded, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(FixturePath(dir, command), append(encoded, '\n'), 0644)
+}
+
+// replayFixture loads the fixture for command and returns its output and the
+// CommandError it should produce, if any.
+func replayFixture(dir string, command Command) ([]byte, *CommandError) {
+	cmdErr := &CommandError{
+		Command:  command.Command,
+		Args:     command.Args,
+		ExitCode: -1,
+	}
+
+	path := FixturePath(dir, command)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		cmdErr.Err = &FixtureError{Path: path, Err: err}
+		return nil, cmdErr
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		cmdErr.Err = &FixtureError{Path: path, Err: err}
+		return nil, cmdErr
+	}
+
+	cmdErr.ExitCode = fixture.ExitCode
+	cmdErr.Signal = fixture.Signal
+	cmdErr.Stderr = []byte(fixture.Stderr)
+	cmdErr.Wall = fixture.Wall
+	switch {
+	case fixture.TimedOut:
+		cmdErr.TimedOut = true
+		cmdErr.Err = &TimeoutError{}
+	case fixture.Signal != 0:
+		cmdErr.Err = fmt.Errorf("replayed signal %v", fixture.Signal)
+	case fixture.ExitCode != 0:
+		cmdErr.Err = fmt.Errorf("replayed exit status %d", fixture.ExitCode)
+	default:
+		cmdErr = nil
+	}
+
+	return []byte(fixture.Stdout), cmdErr
+}
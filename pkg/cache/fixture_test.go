@@ -0,0 +1,93 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"reflect"
+	"testing"
+
+	"experimental/dwat/gosense/pkg/cache"
+)
+
+// TestFixtures tests that recorded runs replay the same way.
+func TestFixtures(t *testing.T) {
+	dir := t.TempDir()
+	t.Cleanup(func() { cache.SetFixtures(cache.FixtureOff, "") })
+
+	var testsTable = []struct {
+		name    string
+		command cache.Command
+	}{
+		{name: "successes", command: cache.Command{Command: "sh", Args: []string{"-c", "echo 'Fan 1 front: 7500 RPM'"}, Timeout: 1}},
+		{name: "failures", command: cache.Command{Command: "sh", Args: []string{"-c", "echo partial; echo 'No sensors found!' >&2; exit 1"}, Timeout: 1}},
+		{name: "timeouts", command: cache.Command{Command: "sleep", Args: []string{"5"}, Timeout: 0}},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			cache.SetFixtures(cache.FixtureRecord, dir)
+			recordedOutput, recordedErr := cache.RunCommand(tt.command)
+			if _, err := os.Stat(cache.FixturePath(dir, tt.command)); err != nil {
+				t.Fatalf("Fixture observed %v, expected it to exist", err)
+			}
+
+			// Replay must not run anything, so break the command.
+			cache.SetFixtures(cache.FixtureReplay, dir)
+			replayed := tt.command
+			replayed.Timeout = -1
+			replayed.Env = []string{"PATH="}
+			replayed.CleanEnv = true
+			replayedOutput, replayedErr := cache.RunCommand(replayed)
+
+			if !bytes.Equal(recordedOutput, replayedOutput) {
+				t.Errorf("Output observed %q, expected %q", replayedOutput, recordedOutput)
+			}
+			if (recordedErr == nil) != (replayedErr == nil) || (recordedErr != nil && cache.Classify(recordedErr) != cache.Classify(replayedErr)) {
+				t.Errorf("Error observed %v, expected %v", replayedErr, recordedErr)
+			}
+
+			var recordedCmdErr, replayedCmdErr *cache.CommandError
+			if errors.As(recordedErr, &recordedCmdErr) {
+				if !errors.As(replayedErr, &replayedCmdErr) {
+					t.Fatalf("Error observed %v, expected *cache.CommandError", replayedErr)
+				}
+				if recordedCmdErr.ExitCode != replayedCmdErr.ExitCode || recordedCmdErr.TimedOut != replayedCmdErr.TimedOut ||
+					!bytes.Equal(recordedCmdErr.Stderr, replayedCmdErr.Stderr) {
+					t.Errorf("Error observed %+v, expected %+v", replayedCmdErr, recordedCmdErr)
+				}
+			}
+		})
+	}
+
+	// Streaming works from fixtures too.
+	var lines []string
+	err := cache.StreamCommand(testsTable[0].command, func(line []byte) error {
+		lines = append(lines, string(line))
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(lines, []string{"Fan 1 front: 7500 RPM"}) {
+		t.Errorf("Lines observed %q, err %v, expected one line", lines, err)
+	}
+
+	// Commands without fixtures fail without running.
+	_, err = cache.RunCommand(cache.Command{Command: "sensors", Args: []string{"-j"}})
+	if !errors.Is(err, fs.ErrNotExist) || cache.Classify(err) != cache.KindFixture {
+		t.Errorf("Error observed %v, expected %v", err, fs.ErrNotExist)
+	}
+}
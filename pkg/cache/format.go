@@ -16,26 +16,60 @@ package cache
 
 import (
 	"encoding/json"
-	"fmt"
+	"time"
 )
 
 // TODO(dwat): The format(s) could use some work. For example it would be
 // helpful if future formats reported the version of gosense used to generate
-// the data. We might also want to consider supporting the Prometheus v2 format
-// for easier integration with ODS and Kubernetes:
-// https://github.com/prometheus/docs/blob/master/content/docs/instrumenting/exposition_formats.md
-// https://github.com/prometheus/client_golang
+// the data.
 
 const (
 	// UnknownValue is the value of the cache before it is started.
 	UnknownValue = `{"unknown": "!?"}`
-	// ErrorValue is the value of the cache if update fails.
+	// ErrorValue was the value of the cache if update fails.
+	//
+	// Deprecated: ErrorValue produces invalid JSON when the error contains
+	// quotes, backslashes or newlines. Use FormatError or NewErrorReport.
 	ErrorValue = `{"err": "%v"}`
 )
 
+// ErrorReport is the value of the cache if update fails. The err field is
+// kept from the original format for existing clients.
+type ErrorReport struct {
+	Err       string    `json:"err"`       // Err is the error message
+	Kind      ErrorKind `json:"kind"`      // Kind classifies the error
+	Timestamp time.Time `json:"timestamp"` // Timestamp is when the error occurred
+	Retrying  bool      `json:"retrying"`  // Retrying is true if the monitor will try again
+}
+
+// NewErrorReport returns an ErrorReport describing err at the current time.
+func NewErrorReport(err error, retrying bool) *ErrorReport {
+	return &ErrorReport{
+		Err:       err.Error(),
+		Kind:      Classify(err),
+		Timestamp: time.Now().UTC(),
+		Retrying:  retrying,
+	}
+}
+
+// StatusCode returns the HTTP status code used to serve the error.
+func (e *ErrorReport) StatusCode() int {
+	return e.Kind.StatusCode()
+}
+
+// Encode returns the JSON representation of the error report.
+func (e *ErrorReport) Encode() []byte {
+	encoded, err := json.Marshal(e)
+	if err != nil {
+		return []byte(nil)
+	}
+
+	return encoded
+}
+
 // FormatError returns a JSON message containing the error.
 func FormatError(err error) []byte {
-	return []byte(fmt.Sprintf(ErrorValue, err))
+	return NewErrorReport(err, false).Encode()
 }
 
 // ClassicReport is the original monitoring API.
@@ -0,0 +1,135 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// Go can not run code between fork and exec, so commands with Limits are
+// started traced: the kernel stops them with SIGTRAP as soon as they have
+// exec'd, before running any of their own code. gosense then applies the
+// limits by pid, rlimits with prlimit(2), the nice value with setpriority(2)
+// and the I/O priority with ioprio_set(2), and detaches to let the command
+// run. This keeps gosense free of external dependencies such as nice(1) or
+// prlimit(1). The limits are applied with gosense's own credentials, so a
+// negative nice value requires CAP_SYS_NICE and a cgroup must be writable by
+// gosense. Commands can not be started with limits where ptrace(2) is denied,
+// e.g. with kernel.yama.ptrace_scope=3.
+
+// IOClass is an I/O scheduling class, see ioprio_set(2).
+type IOClass int
+
+const (
+	IOClassNone       IOClass = iota // Leave the I/O class unchanged
+	IOClassRealtime                  // Realtime I/O, requires CAP_SYS_ADMIN
+	IOClassBestEffort                // Best effort I/O, the default for most processes
+	IOClassIdle                      // Only do I/O when nobody else is
+)
+
+const (
+	ioprioWhoProcess = 1  // IOPRIO_WHO_PROCESS
+	ioprioClassShift = 13 // IOPRIO_CLASS_SHIFT
+)
+
+// Limits constrain the resources a command may use so that monitoring does
+// not disturb the workload. The zero value imposes no limits.
+type Limits struct {
+	Nice         int     // Nice value, 0 leaves the priority unchanged
+	IOClass      IOClass // I/O scheduling class, IOClassNone leaves it unchanged
+	IOLevel      int     // I/O priority within IOClass, 0 (highest) to 7 (lowest)
+	CPUSeconds   uint64  // RLIMIT_CPU in seconds, 0 is unlimited
+	AddressSpace uint64  // RLIMIT_AS in bytes, 0 is unlimited
+	OpenFiles    uint64  // RLIMIT_NOFILE, 0 is unlimited
+	Cgroup       string  // Cgroup v2 directory to place the process in, "" leaves it unchanged
+}
+
+// release waits for the traced process pid to stop after exec, applies the
+// limits to it and detaches from it, letting it run. It must be called from
+// the thread which started the process, which is its tracer. On error the
+// process is left stopped for the caller to kill.
+func (l Limits) release(pid int) error {
+	var status syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &status, syscall.WALL, nil); err != nil {
+		return fmt.Errorf("limits: wait: %w", err)
+	}
+	if !status.Stopped() {
+		return fmt.Errorf("limits: process did not stop after exec: %v", status)
+	}
+
+	if err := l.apply(pid); err != nil {
+		return err
+	}
+
+	return syscall.PtraceDetach(pid)
+}
+
+// apply applies the limits to the process pid. The process is placed in the
+// cgroup first so that everything it forks is accounted there.
+func (l Limits) apply(pid int) error {
+	if l.Cgroup != "" {
+		procs := filepath.Join(l.Cgroup, "cgroup.procs")
+		if err := os.WriteFile(procs, []byte(strconv.Itoa(pid)), 0); err != nil {
+			return fmt.Errorf("limits: cgroup: %w", err)
+		}
+	}
+
+	if l.IOClass != IOClassNone {
+		prio := uintptr(l.IOClass)<<ioprioClassShift | uintptr(l.IOLevel)
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), prio); errno != 0 {
+			return fmt.Errorf("limits: ioprio: %w", errno)
+		}
+	}
+
+	if l.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, l.Nice); err != nil {
+			return fmt.Errorf("limits: nice: %w", err)
+		}
+	}
+
+	for _, rlimit := range []struct {
+		resource int
+		value    uint64
+	}{
+		{resource: syscall.RLIMIT_CPU, value: l.CPUSeconds},
+		{resource: syscall.RLIMIT_NOFILE, value: l.OpenFiles},
+		{resource: syscall.RLIMIT_AS, value: l.AddressSpace},
+	} {
+		if rlimit.value == 0 {
+			continue
+		}
+		if err := prlimit(pid, rlimit.resource, &syscall.Rlimit{Cur: rlimit.value, Max: rlimit.value}); err != nil {
+			return fmt.Errorf("limits: rlimit %d: %w", rlimit.resource, err)
+		}
+	}
+
+	return nil
+}
+
+// prlimit sets the resource limit of the process pid, see prlimit(2). The
+// syscall package only sets limits of the calling process.
+func prlimit(pid, resource int, limit *syscall.Rlimit) error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(limit)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Usage describes the resources used by one or more processes.
+type Usage struct {
+	Runs        int64         `json:"runs"`         // Runs is the number of processes measured
+	UserTime    time.Duration `json:"user_ns"`      // UserTime is the total user CPU time
+	SystemTime  time.Duration `json:"system_ns"`    // SystemTime is the total system CPU time
+	MaxRSS      int64         `json:"max_rss_kb"`   // MaxRSS is the largest resident set size in kilobytes
+	MinorFaults int64         `json:"minor_faults"` // MinorFaults is the total number of page reclaims
+	MajorFaults int64         `json:"major_faults"` // MajorFaults is the total number of page faults requiring I/O
+}
+
+// newUsage converts a rusage to a Usage of one run.
+func newUsage(rusage *syscall.Rusage) Usage {
+	return Usage{
+		Runs:        1,
+		UserTime:    time.Duration(rusage.Utime.Nano()),
+		SystemTime:  time.Duration(rusage.Stime.Nano()),
+		MaxRSS:      int64(rusage.Maxrss),
+		MinorFaults: int64(rusage.Minflt),
+		MajorFaults: int64(rusage.Majflt),
+	}
+}
+
+// Add returns the sum of u and v. MaxRSS is the maximum of the two.
+func (u Usage) Add(v Usage) Usage {
+	sum := Usage{
+		Runs:        u.Runs + v.Runs,
+		UserTime:    u.UserTime + v.UserTime,
+		SystemTime:  u.SystemTime + v.SystemTime,
+		MaxRSS:      u.MaxRSS,
+		MinorFaults: u.MinorFaults + v.MinorFaults,
+		MajorFaults: u.MajorFaults + v.MajorFaults,
+	}
+	if v.MaxRSS > sum.MaxRSS {
+		sum.MaxRSS = v.MaxRSS
+	}
+
+	return sum
+}
+
+// Accounting accumulates the Usage of commands run with it. It is safe for
+// concurrent use.
+type Accounting struct {
+	mu    sync.Mutex
+	total Usage
+}
+
+// Add adds u to the total.
+func (a *Accounting) Add(u Usage) {
+	a.mu.Lock()
+	a.total = a.total.Add(u)
+	a.mu.Unlock()
+}
+
+// Total returns the accumulated Usage.
+func (a *Accounting) Total() Usage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.total
+}
+
+// SelfUsage returns the resources used by gosense itself, and by all of its
+// children which have been waited for.
+func SelfUsage() (self Usage, children Usage, err error) {
+	var rusage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err != nil {
+		return self, children, err
+	}
+	self = newUsage(&rusage)
+
+	if err := syscall.Getrusage(syscall.RUSAGE_CHILDREN, &rusage); err != nil {
+		return self, children, err
+	}
+	children = newUsage(&rusage)
+	children.Runs = 0
+
+	return self, children, nil
+}
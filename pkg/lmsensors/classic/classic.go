@@ -1,8 +1,11 @@
 package classic
 
 import (
+	"bytes"
+	"encoding/json"
 	"regexp"
 	"strings"
+	"syscall"
 
 	"experimental/dwat/gosense/pkg/cache"
 	"experimental/dwat/gosense/pkg/report"
@@ -11,14 +14,72 @@ import (
 const (
 	commandPath    = "sensors" // Relative path to command
 	processTimeout = 10        // Number of seconds before we attempt to kill the process
+	maxOutput      = 1 << 20   // Number of bytes of output before we kill the process
 )
 
+// SHA256 is the expected hex digest of the sensors executable. If it is empty
+// the digest is reported but not verified. It must be set before the first
+// update because the executable is pinned.
+var SHA256 string
+
+// Credential, if set, is used to run sensors as an unprivileged user. Reading
+// hwmon does not require root.
+var Credential *syscall.Credential
+
+// Chips, if set, limits sensors to these chips, e.g. tmp75-i2c-3-48 or
+// coretemp-*, instead of scanning every chip. It must be set before the first
+// update.
+var Chips []string
+
+// accounting accumulates the resources used by sensors processes.
+var accounting cache.Accounting
+
+// command returns the command to run. Chips follow "--" so they can not be
+// mistaken for flags.
+func command() cache.Command {
+	var args []string
+	if len(Chips) > 0 {
+		args = append([]string{"--"}, Chips...)
+	}
+
+	return cache.Command{
+		Command:    commandPath,
+		Args:       args,
+		Timeout:    processTimeout,
+		MaxOutput:  maxOutput,
+		CleanEnv:   true,
+		Pin:        true,
+		SHA256:     SHA256,
+		Credential: Credential,
+		Accounting: &accounting,
+	}
+}
+
+// Usage returns the resources used by all sensors processes so far.
+func Usage() cache.Usage {
+	return accounting.Total()
+}
+
+// Resolve pins the sensors executable, verifying it against SHA256, so that a
+// mismatch can be reported at startup rather than by every update.
+func Resolve() error {
+	_, err := cache.Resolve(command())
+	return err
+}
+
+// Metadata describes the pinned sensors executable.
+func Metadata() map[string]string {
+	exe, err := cache.Resolve(command())
+	if err != nil {
+		return map[string]string{"err": err.Error()}
+	}
+
+	return exe.Metadata()
+}
+
 // Update runs the command and renders its output in the classic format.
 func Update() ([]byte, error) {
-	output, err := cache.RunCommand(cache.Command{
-		Command: commandPath,
-		Timeout: processTimeout,
-	})
+	output, err := cache.RunCommand(command())
 
 	if err != nil {
 		return []byte(nil), err
@@ -27,6 +88,36 @@ func Update() ([]byte, error) {
 	return Format(output), nil
 }
 
+// UpdateReadings runs the command and renders its readings, including the
+// thresholds and alarms which the classic format throws away.
+func UpdateReadings() ([]byte, error) {
+	output, err := cache.RunCommand(command())
+
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	return FormatReadings(output)
+}
+
+// Decode converts the data returned by Update or UpdateReadings to readings.
+func Decode(data []byte) ([]report.Reading, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var readings []report.Reading
+		if err := json.Unmarshal(data, &readings); err != nil {
+			return nil, err
+		}
+		return readings, nil
+	}
+
+	var classic report.ClassicReport
+	if err := json.Unmarshal(data, &classic); err != nil {
+		return nil, err
+	}
+
+	return report.ReadingsFromClassic(classic), nil
+}
+
 // Format takes stdout from the sensors command and returns the classic format
 // defined by the python implementation here:
 // https://fburl.com/diffusion/scvlbt7e
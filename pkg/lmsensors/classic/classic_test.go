@@ -1,7 +1,9 @@
 package classic_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"os"
 	"reflect"
 	"testing"
 
@@ -10,8 +12,10 @@ import (
 	"experimental/dwat/gosense/pkg/report"
 )
 
-// classicFormatTests ensures real world hardware looks the same with new
-// code. To generate new wants for wedge100:
+// TestClassicFormat ensures real world hardware looks the same with new code.
+// testdata/wedge100/service.json is the output of the original service for
+// testdata/wedge100/sensors.txt, checked by hand; unlike classic.json it is
+// never regenerated. To generate a new service.json for wedge100:
 //
 // $ bmc=$(randbox asset_tagging.openbmc.wedge100)
 // $ curl -s -k https://$bmc:8443/api/sys/sensors | pastebin
@@ -23,96 +27,153 @@ import (
 // https://phabricator.intern.facebook.com/P110358789
 //
 // *Note* you will likely have to fix up the temperature values in order for
-// the tests to pass. Also, the test cases are purposely []byte(s) to make it
-// easier for developers to add test cases.
-var classicFormatTests = []struct {
-	name          string
-	serviceOutput []byte
-	sensorsOutput []byte
-}{
-	{
-		name:          "wedge100",
-		serviceOutput: []byte(`{"Information": [{"Adapter": "ast_i2c.3", "Outlet Middle Temp": "+26.5 C", "name": "tmp75-i2c-3-48"}, {"Adapter": "ast_i2c.3", "Inlet Middle Temp": "+22.6 C", "name": "tmp75-i2c-3-49"}, {"Adapter": "ast_i2c.3", "Inlet Left Temp": "+22.2 C", "name": "tmp75-i2c-3-4a"}, {"Adapter": "ast_i2c.3", "Switch Temp": "+37.3 C", "name": "tmp75-i2c-3-4b"}, {"Adapter": "ast_i2c.3", "Inlet Right Temp": "+24.0 C", "name": "tmp75-i2c-3-4c"}, {"+5V Voltage": "+5.06 V", "CPU Temp": "+48.0 C", "+12V Voltage": "+12.37 V", "+3V Voltage": "+3.28 V", "Adapter": "ast_i2c.4", "VDIMM Voltage": "+1.21 V", "CPU Vcore": "+1.80 V", "name": "com_e_driver-i2c-4-33", "Memory Temp": "+33.5 C"}, {"vout1": "+12.45 V", "Adapter": "ast_i2c.7", "iout1": "+9.58 A", "name": "ltc4151-i2c-7-6f"}, {"Fan 2 rear": "4800 RPM", "Fan 5 front": "7500 RPM", "Fan 3 rear": "4950 RPM", "Adapter": "ast_i2c.8", "name": "fancpld-i2c-8-33", "Fan 1 rear": "4950 RPM", "Fan 1 front": "7500 RPM", "Fan 2 front": "7500 RPM", "Fan 4 front": "7500 RPM", "Fan 4 rear": "4800 RPM", "Fan 3 front": "7500 RPM", "Fan 5 rear": "4950 RPM"}, {"Adapter": "ast_i2c.8", "name": "tmp75-i2c-8-48", "Outlet Right Temp": "+23.4 C"}, {"Outlet Left Temp": "+22.0 C", "Adapter": "ast_i2c.8", "name": "tmp75-i2c-8-49"}], "Resources": [], "Actions": []}`),
-		sensorsOutput: []byte(`tmp75-i2c-3-48
-Adapter: ast_i2c.3
-Outlet Middle Temp:  +26.5 C  (high = +80.0 C, hyst = +75.0 C)
-
-tmp75-i2c-3-49
-Adapter: ast_i2c.3
-Inlet Middle Temp:  +22.6 C  (high = +80.0 C, hyst = +75.0 C)
-
-tmp75-i2c-3-4a
-Adapter: ast_i2c.3
-Inlet Left Temp:  +22.2 C  (high = +80.0 C, hyst = +75.0 C)
-
-tmp75-i2c-3-4b
-Adapter: ast_i2c.3
-Switch Temp:  +37.3 C  (high = +80.0 C, hyst = +75.0 C)
-
-tmp75-i2c-3-4c
-Adapter: ast_i2c.3
-Inlet Right Temp:  +24.0 C  (high = +80.0 C, hyst = +75.0 C)
-
-com_e_driver-i2c-4-33
-Adapter: ast_i2c.4
-CPU Vcore:      +1.80 V
-+3V Voltage:    +3.28 V
-+5V Voltage:    +5.06 V
-+12V Voltage:  +12.37 V
-VDIMM Voltage:  +1.21 V
-Memory Temp:    +33.5 C
-CPU Temp:       +48.0 C
-
-ltc4151-i2c-7-6f
-Adapter: ast_i2c.7
-vout1:       +12.45 V
-iout1:        +9.58 A
-
-fancpld-i2c-8-33
-Adapter: ast_i2c.8
-Fan 1 front: 7500 RPM
-Fan 1 rear:  4950 RPM
-Fan 2 front: 7500 RPM
-Fan 2 rear:  4800 RPM
-Fan 3 front: 7500 RPM
-Fan 3 rear:  4950 RPM
-Fan 4 front: 7500 RPM
-Fan 4 rear:  4800 RPM
-Fan 5 front: 7500 RPM
-Fan 5 rear:  4950 RPM
-
-tmp75-i2c-8-48
-Adapter: ast_i2c.8
-Outlet Right Temp:  +23.4 C  (high = +80.0 C, hyst = +75.0 C)
-
-tmp75-i2c-8-49
-Adapter: ast_i2c.8
-Outlet Left Temp:  +22.0 C  (high = +80.0 C, hyst = +75.0 C)`),
-	},
-}
-
+// the test to pass.
 func TestClassicFormat(t *testing.T) {
 	var observed, expected report.ClassicReport
 
-	for _, tt := range classicFormatTests {
-		err := json.Unmarshal(tt.serviceOutput, &expected)
-		if err != nil {
-			t.Fatalf("Failed to unmarshal expected JSON %v\n", err)
-		}
+	if err := json.Unmarshal(readTestdata(t, "wedge100", "service.json"), &expected); err != nil {
+		t.Fatalf("Failed to unmarshal expected JSON %v\n", err)
+	}
+	if err := json.Unmarshal(classic.Format(readTestdata(t, "wedge100", "sensors.txt")), &observed); err != nil {
+		t.Fatalf("Failed to unmarshal observed JSON %v\n", err)
+	}
 
-		text := classic.Format(tt.sensorsOutput)
-		err = json.Unmarshal(text, &observed)
-		if err != nil {
-			t.Fatalf("Failed to unmarshal observed JSON %v\n", err)
-		}
+	if !reflect.DeepEqual(expected, observed) {
+		t.Fatalf("Classic format for wedge100 does not match, observed \n%s\n, expected \n%s\n", observed, expected)
+	}
+}
 
-		if !reflect.DeepEqual(expected, observed) {
-			t.Fatalf("Classic format for %s does not match, observed \n%s\n, expected \n%s\n", tt.name, observed, expected)
-		}
+// TestParseAnnotations tests alarms, continued annotations, values without a
+// space before their unit and values which can not be parsed.
+func TestParseAnnotations(t *testing.T) {
+	output := []byte(`nct6775-isa-0290
+Adapter: ISA adapter
+in0:          +0.90 V  (min =  +0.00 V, max =  +1.74 V)
+fan1:           0 RPM  (min =    0 RPM, div = 8)  ALARM
+fan2:         N/A
+temp1:        +95.0°C  (high = +80.0°C, hyst = +75.0°C)  ALARM (CRIT)
+                       (crit = +90.0°C, hyst = +85.0°C)  sensor = thermistor
+in1:          +1.20 V  (crit min =  +0.80 V, crit max =  +1.60 V)
+temp2:        +40.0°C  (low  = +10.0°C, hyst = +12.0°C)
+                       (high = +80.0°C, hyst = +75.0°C)
+                       (crit low = +0.0°C, crit low hyst = +2.0°C)
+                       (crit = +100.0°C, crit hyst = +95.0°C)
+                       (emerg = +110.0°C, emerg hyst = +105.0°C)
+                       (lowest = +20.0°C, highest = +60.0°C)
+`)
+
+	expected := []report.Reading{
+		{Chip: "nct6775-isa-0290", Adapter: "ISA adapter", Label: "in0", Kind: report.KindVoltage, Value: 0.9,
+			Thresholds: map[string]float64{"min": 0, "max": 1.74}},
+		{Chip: "nct6775-isa-0290", Adapter: "ISA adapter", Label: "fan1", Kind: report.KindFan, Value: 0,
+			Thresholds: map[string]float64{"min": 0}, Alarms: map[string]bool{"alarm": true}},
+		{Chip: "nct6775-isa-0290", Adapter: "ISA adapter", Label: "temp1", Kind: report.KindTemperature, Value: 95,
+			Thresholds: map[string]float64{"max": 80, "max_hyst": 75, "crit": 90, "crit_hyst": 85}, Alarms: map[string]bool{"crit_alarm": true}},
+		{Chip: "nct6775-isa-0290", Adapter: "ISA adapter", Label: "in1", Kind: report.KindVoltage, Value: 1.2,
+			Thresholds: map[string]float64{"lcrit": 0.8, "crit": 1.6}},
+		{Chip: "nct6775-isa-0290", Adapter: "ISA adapter", Label: "temp2", Kind: report.KindTemperature, Value: 40,
+			Thresholds: map[string]float64{"min": 10, "min_hyst": 12, "max": 80, "max_hyst": 75, "lcrit": 0, "lcrit_hyst": 2,
+				"crit": 100, "crit_hyst": 95, "emergency": 110, "emergency_hyst": 105, "lowest": 20, "highest": 60}},
+	}
+
+	observed := classic.Parse(output)
+	if !reflect.DeepEqual(expected, observed) {
+		t.Errorf("Parse observed \n%+v\n, expected \n%+v\n", observed, expected)
+	}
+
+	encoded, err := classic.FormatReadings(output)
+	if err != nil {
+		t.Fatalf("FormatReadings failed %v", err)
+	}
+	decoded, err := classic.Decode(encoded)
+	if err != nil || !reflect.DeepEqual(expected, decoded) {
+		t.Errorf("Decode observed %+v %v, expected %+v", decoded, err, expected)
+	}
+}
+
+// TestUpdateReplay tests Update end to end against sensors output recorded on
+// a wedge100. To record new fixtures run gosense on the machine with
+// -record-fixtures <dir> and copy the results into testdata/<platform>.
+func TestUpdateReplay(t *testing.T) {
+	cache.SetFixtures(cache.FixtureReplay, "testdata/wedge100")
+	defer cache.SetFixtures(cache.FixtureOff, "")
+
+	observed, err := classic.Update()
+	if err != nil {
+		t.Fatalf("Update failed %v\n", err)
+	}
+
+	expected := readTestdata(t, "wedge100", "classic.json")
+	if !bytes.Equal(expected, observed) {
+		t.Fatalf("Replayed update does not match, observed \n%s\n, expected \n%s\n", observed, expected)
 	}
 }
 
-// Benchmark profiles Get and UpdateWithTimeout.
+// TestUpdateChips tests that Update only asks sensors for the configured
+// chips.
+func TestUpdateChips(t *testing.T) {
+	dir := t.TempDir()
+	stdout := "tmp75-i2c-3-48\nAdapter: ast_i2c.3\nOutlet Middle Temp:  +26.5 C  (high = +80.0 C, hyst = +75.0 C)\n\n"
+	fixture := cache.Fixture{Command: "sensors", Args: []string{"--", "tmp75-*"}, Stdout: stdout}
+	encoded, err := json.Marshal(fixture)
+	if err != nil {
+		t.Fatalf("Failed to marshal fixture %v", err)
+	}
+	if err := os.WriteFile(cache.FixturePath(dir, cache.Command{Command: "sensors", Args: fixture.Args}), encoded, 0644); err != nil {
+		t.Fatalf("Failed to write fixture %v", err)
+	}
+
+	cache.SetFixtures(cache.FixtureReplay, dir)
+	classic.Chips = []string{"tmp75-*"}
+	defer func() {
+		cache.SetFixtures(cache.FixtureOff, "")
+		classic.Chips = nil
+	}()
+
+	observed, err := classic.Update()
+	if err != nil {
+		t.Fatalf("Update failed %v", err)
+	}
+	expected := `{"Information":[{"Adapter":"ast_i2c.3","Outlet Middle Temp":"+26.5 C","name":"tmp75-i2c-3-48"}],"Actions":[],"Resources":[]}`
+	if string(observed) != expected {
+		t.Errorf("Update of chips observed %s, expected %s", observed, expected)
+	}
+}
+
+// TestDecode tests that the classic format decodes to typed readings.
+func TestDecode(t *testing.T) {
+	readings, err := classic.Decode(readTestdata(t, "wedge100", "classic.json"))
+	if err != nil {
+		t.Fatalf("Decode failed %v\n", err)
+	}
+
+	kinds := make(map[report.Kind]int)
+	for _, r := range readings {
+		kinds[r.Kind]++
+	}
+	expected := map[report.Kind]int{
+		report.KindTemperature: 9,
+		report.KindVoltage:     6,
+		report.KindCurrent:     1,
+		report.KindFan:         10,
+	}
+	if !reflect.DeepEqual(expected, kinds) {
+		t.Errorf("Decoded kinds observed %v, expected %v", kinds, expected)
+	}
+
+	first := report.Reading{Chip: "tmp75-i2c-3-48", Adapter: "ast_i2c.3", Label: "Outlet Middle Temp", Kind: report.KindTemperature, Value: 26.5}
+	if len(readings) == 0 || !reflect.DeepEqual(readings[0], first) {
+		t.Errorf("First reading observed %+v, expected %+v", readings, first)
+	}
+
+	if _, err := classic.Decode([]byte("not json")); err == nil {
+		t.Errorf("Decode of invalid JSON observed nil error")
+	}
+}
+
+// Benchmark profiles Get and UpdateWithTimeout. The resources used by the
+// sensors process itself are reported per op as child-cpu-ns, child-max-rss-kB
+// and child-faults.
 func Benchmark(b *testing.B) {
 	c := cache.NewCache("csensor", classic.Update, 60*60*24*365)
 	b.ResetTimer()
@@ -124,8 +185,18 @@ func Benchmark(b *testing.B) {
 	})
 
 	b.Run("c.UpdateWithTimeout()", func(b *testing.B) {
+		before := classic.Usage()
 		for i := 0; i < b.N; i++ {
 			_ = c.UpdateWithTimeout(false)
 		}
+		after := classic.Usage()
+
+		if runs := after.Runs - before.Runs; runs > 0 {
+			cpu := (after.UserTime + after.SystemTime) - (before.UserTime + before.SystemTime)
+			faults := (after.MinorFaults + after.MajorFaults) - (before.MinorFaults + before.MajorFaults)
+			b.ReportMetric(float64(cpu.Nanoseconds())/float64(runs), "child-cpu-ns/op")
+			b.ReportMetric(float64(after.MaxRSS), "child-max-rss-kB")
+			b.ReportMetric(float64(faults)/float64(runs), "child-faults/op")
+		}
 	})
 }
@@ -0,0 +1,111 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classic_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"experimental/dwat/gosense/pkg/lmsensors/classic"
+	"experimental/dwat/gosense/pkg/report"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected outputs in testdata")
+
+// platforms make up the corpus of real world sensors output. Every platform
+// has a directory in testdata with:
+//
+//   - sensors.txt, the output of sensors
+//   - classic.json, the classic format of sensors.txt
+//   - normalized.json, the readings parsed from sensors.txt
+//
+// Platforms may also have the output of sensors -u in sensors-u.txt, along
+// with the readings parsed from it in normalized-u.json and the classic format
+// of those readings in classic-u.json. wedge100 also has service.json, the
+// hand-checked output of the original service, see TestClassicFormat.
+//
+// To add a platform, capture the output of sensors (and sensors -u) on the
+// machine into a new directory, add it below and regenerate the expected
+// outputs:
+//
+// $ go test ./pkg/lmsensors/classic -run TestCorpus -update
+//
+// Review the generated files before committing them, they are only as correct
+// as the parsers which wrote them.
+var platforms = []string{"laptop", "wedge100", "x86"}
+
+// readTestdata returns the contents of name in the testdata of platform.
+func readTestdata(t testing.TB, platform, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", platform, name))
+	if err != nil {
+		t.Fatalf("Failed to read testdata %v", err)
+	}
+	return data
+}
+
+// checkGolden fails if observed differs from name in the testdata of
+// platform, or rewrites name with observed when -update is given.
+func checkGolden(t *testing.T, platform, name string, observed []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", platform, name)
+	if *update {
+		if err := os.WriteFile(path, observed, 0644); err != nil {
+			t.Fatalf("Failed to update %s %v", path, err)
+		}
+		return
+	}
+
+	expected := readTestdata(t, platform, name)
+	if !bytes.Equal(observed, expected) {
+		t.Errorf("%s observed \n%s\n, expected \n%s\n", path, observed, expected)
+	}
+}
+
+// marshalReadings returns readings as they are stored in normalized.json.
+func marshalReadings(t *testing.T, readings []report.Reading) []byte {
+	t.Helper()
+
+	encoded, err := json.MarshalIndent(readings, "", "  ")
+	if err != nil {
+		t.Fatalf("Failed to marshal readings %v", err)
+	}
+	return append(encoded, '\n')
+}
+
+// TestCorpus tests Format, Parse and ParseRaw against the expected outputs of
+// every platform.
+func TestCorpus(t *testing.T) {
+	for _, platform := range platforms {
+		t.Run(platform, func(t *testing.T) {
+			output := readTestdata(t, platform, "sensors.txt")
+			checkGolden(t, platform, "classic.json", classic.Format(output))
+			checkGolden(t, platform, "normalized.json", marshalReadings(t, classic.Parse(output)))
+
+			if _, err := os.Stat(filepath.Join("testdata", platform, "sensors-u.txt")); os.IsNotExist(err) {
+				return
+			}
+			readings := classic.ParseRaw(readTestdata(t, platform, "sensors-u.txt"))
+			checkGolden(t, platform, "normalized-u.json", marshalReadings(t, readings))
+			checkGolden(t, platform, "classic-u.json", report.FormatClassicInformation(report.ClassicInformation(readings)))
+		})
+	}
+}
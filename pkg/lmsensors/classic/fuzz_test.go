@@ -0,0 +1,140 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classic_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"experimental/dwat/gosense/pkg/cache"
+	"experimental/dwat/gosense/pkg/lmsensors/classic"
+	"experimental/dwat/gosense/pkg/report"
+)
+
+// The fuzz targets below are seeded with every output captured in testdata.
+// To fuzz one of them:
+//
+// $ go test -fuzz=FuzzFormat -fuzztime=1m ./pkg/lmsensors/classic
+
+// addSeeds adds the output of every fixture in testdata whose arguments are
+// args, and every text file whose name starts with prefix, to the seed
+// corpus.
+func addSeeds(f *testing.F, args []string, prefix string) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*", "sensors*"))
+	if err != nil {
+		f.Fatalf("Failed to list testdata %v", err)
+	}
+
+	for _, path := range paths {
+		encoded, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatalf("Failed to read %s %v", path, err)
+		}
+
+		base := filepath.Base(path)
+		switch {
+		case strings.HasSuffix(base, ".txt") && strings.HasPrefix(base, prefix):
+			f.Add(encoded)
+		case base == filepath.Base(cache.FixturePath("", cache.Command{Command: "sensors", Args: args})):
+			var fixture cache.Fixture
+			if err := json.Unmarshal(encoded, &fixture); err != nil {
+				f.Fatalf("Failed to unmarshal fixture %s %v", path, err)
+			}
+			f.Add([]byte(fixture.Stdout))
+		}
+	}
+}
+
+// checkReadings fails if readings are not valid, or do not survive a round
+// trip through the normalized schema.
+func checkReadings(t *testing.T, readings []report.Reading) {
+	for _, r := range readings {
+		if r.Kind == report.KindUnknown {
+			t.Errorf("Reading %+v is of unknown kind", r)
+		}
+	}
+
+	encoded, err := json.Marshal(readings)
+	if err != nil {
+		t.Fatalf("Failed to marshal readings %v", err)
+	}
+	decoded, err := classic.Decode(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode readings %s %v", encoded, err)
+	}
+	if !reflect.DeepEqual(readings, decoded) {
+		t.Errorf("Readings observed %+v after a round trip, expected %+v", decoded, readings)
+	}
+}
+
+// FuzzFormat tests that Format always returns a classic report which is
+// unchanged by a round trip through report.ClassicReport.
+func FuzzFormat(f *testing.F) {
+	addSeeds(f, nil, "sensors.")
+	f.Fuzz(func(t *testing.T, stdout []byte) {
+		encoded := classic.Format(stdout)
+
+		var decoded report.ClassicReport
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("Failed to unmarshal %s %v", encoded, err)
+		}
+		for _, chip := range decoded.Information {
+			if _, ok := chip["name"]; !ok {
+				t.Errorf("Chip %v has no name", chip)
+			}
+		}
+
+		if again := report.FormatClassicInformation(decoded.Information); !bytes.Equal(encoded, again) {
+			t.Errorf("Classic format observed %s after a round trip, expected %s", again, encoded)
+		}
+	})
+}
+
+// FuzzParse tests that Parse always returns valid readings.
+func FuzzParse(f *testing.F) {
+	addSeeds(f, nil, "sensors.")
+	// A threshold which overflows once converted to its base unit.
+	f.Add([]byte("power-0\nfoo: +1.0 W (max = 1e308 kW)\n"))
+	f.Fuzz(func(t *testing.T, stdout []byte) {
+		checkReadings(t, classic.Parse(stdout))
+	})
+}
+
+// FuzzParseRaw tests that ParseRaw always returns valid readings.
+func FuzzParseRaw(f *testing.F) {
+	addSeeds(f, []string{"-u"}, "sensors-u.")
+
+	f.Fuzz(func(t *testing.T, stdout []byte) {
+		checkReadings(t, classic.ParseRaw(stdout))
+	})
+}
+
+// FuzzParseJSON tests that ParseJSON either fails or returns valid readings.
+func FuzzParseJSON(f *testing.F) {
+	addSeeds(f, []string{"-j"}, "sensors-j.")
+
+	f.Fuzz(func(t *testing.T, stdout []byte) {
+		readings, err := classic.ParseJSON(stdout)
+		if err != nil {
+			return
+		}
+		checkReadings(t, readings)
+	})
+}
@@ -0,0 +1,214 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"experimental/dwat/gosense/pkg/cache"
+	"experimental/dwat/gosense/pkg/report"
+)
+
+// jsonCommand returns the command printing JSON, supported since lm-sensors
+// 3.5.0.
+func jsonCommand() cache.Command {
+	c := command()
+	c.Args = append([]string{"-j"}, c.Args...)
+	return c
+}
+
+// versionCommand returns the command printing the version of sensors, e.g.
+// "sensors version 3.6.0 with libsensors version 3.6.0".
+func versionCommand() cache.Command {
+	c := command()
+	c.Args = []string{"-v"}
+	return c
+}
+
+var (
+	// unsupportedOption matches what getopt prints for an option sensors
+	// does not know, e.g. "sensors: invalid option -- 'j'".
+	unsupportedOption = regexp.MustCompile(`(?i)(invalid|unrecognized|unknown) option`)
+	// sensorsVersion matches the major and minor version printed by sensors -v.
+	sensorsVersion = regexp.MustCompile(`sensors version (\d+)\.(\d+)`)
+)
+
+// Auto reads sensors with sensors -j if it is supported, and with the classic
+// output and its thresholds otherwise, e.g. on older BMC images, which reject
+// the flag. Either way the cache holds readings. The choice is made by the
+// first update which can tell rather than at startup, since running sensors
+// may take as long as its timeout.
+type Auto struct {
+	mu     sync.Mutex
+	update cache.Update // update is the chosen update, or nil until one is chosen
+}
+
+// Update runs the chosen update, first choosing one if necessary.
+func (a *Auto) Update() ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.update != nil {
+		return a.update()
+	}
+
+	output, err := UpdateJSON()
+	if err == nil {
+		log.Printf("Reading sensors with sensors -j.\n")
+		a.update = UpdateJSON
+		return output, nil
+	}
+
+	switch supported, known := jsonSupported(err); {
+	case !known:
+		// Neither sensors -j nor sensors -v said whether -j is supported,
+		// e.g. sensors timed out, so choose on the next update.
+	case supported:
+		log.Printf("Reading sensors with sensors -j, which failed, err %v.\n", err)
+		a.update = UpdateJSON
+	default:
+		log.Printf("sensors does not support -j, err %v, reading sensors without it.\n", err)
+		a.update = UpdateReadings
+		return a.update()
+	}
+
+	return output, err
+}
+
+// jsonSupported reports whether sensors supports -j, given err from sensors
+// -j, and whether that could be told. sensors rejects the flag on stderr if
+// it does not know it. Otherwise -j failed for another reason, and it is
+// supported if sensors -v prints 3.5.0 or later.
+func jsonSupported(err error) (supported, known bool) {
+	var cmdErr *cache.CommandError
+	if errors.As(err, &cmdErr) && unsupportedOption.Match(cmdErr.Stderr) {
+		return false, true
+	}
+
+	output, err := cache.RunCommand(versionCommand())
+	if err != nil {
+		return false, false
+	}
+	match := sensorsVersion.FindSubmatch(output)
+	if match == nil {
+		return false, false
+	}
+	major, _ := strconv.Atoi(string(match[1]))
+	minor, _ := strconv.Atoi(string(match[2]))
+
+	return major > 3 || major == 3 && minor >= 5, true
+}
+
+// UpdateJSON runs sensors -j and renders its readings, including thresholds
+// and alarms.
+func UpdateJSON() ([]byte, error) {
+	output, err := cache.RunCommand(jsonCommand())
+
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	readings, err := ParseJSON(output)
+	if err != nil {
+		return []byte(nil), &cache.ParseError{Err: err}
+	}
+
+	return json.Marshal(readings)
+}
+
+// ParseJSON takes stdout from sensors -j and returns its readings. The output
+// has the same structure as sensors -u, e.g.
+//
+//	{"coretemp-isa-0000": {"Adapter": "ISA adapter", "Core 0": {"temp2_input": 43.000}}}
+//
+// The order of chips and labels is kept. Features without a value are skipped.
+func ParseJSON(stdout []byte) ([]report.Reading, error) {
+	readings := make([]report.Reading, 0)
+	dec := json.NewDecoder(bytes.NewReader(stdout))
+
+	err := decodeObject(dec, func(chip string) error {
+		adapter := ""
+		features := make([]*feature, 0)
+
+		err := decodeObject(dec, func(label string) error {
+			if label == "Adapter" {
+				return dec.Decode(&adapter)
+			}
+
+			f := &feature{label: label, subfeatures: make(map[string]float64)}
+			features = append(features, f)
+			return decodeObject(dec, func(subfeature string) error {
+				var value interface{}
+				if err := dec.Decode(&value); err != nil {
+					return err
+				}
+				name := strings.SplitN(subfeature, "_", 2)
+				if number, ok := value.(float64); ok && len(name) == 2 {
+					f.name = name[0]
+					f.subfeatures[name[1]] = number
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, f := range features {
+			if r, ok := f.reading(chip, adapter); ok {
+				readings = append(readings, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return readings, nil
+}
+
+// decodeObject decodes a JSON object from dec, calling fn for each key in
+// order. fn must decode the value of the key.
+func decodeObject(dec *json.Decoder, fn func(key string) error) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("classic: expected an object, found %v", token)
+	}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if err := fn(token.(string)); err != nil {
+			return err
+		}
+	}
+
+	_, err = dec.Token()
+	return err
+}
@@ -0,0 +1,194 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classic
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"experimental/dwat/gosense/pkg/report"
+)
+
+var (
+	// annotation matches the parenthesised annotations printed after a
+	// value, e.g. "(high = +80.0 C, hyst = +75.0 C)".
+	annotation = regexp.MustCompile(`\(([^)]*)\)`)
+	// valuePattern matches a value and its unit, e.g. "+26.5 C" or "+27.8°C".
+	valuePattern = regexp.MustCompile(`^\s*([+-]?[0-9.]+ ?[^\s(]+)`)
+	// alarm matches an alarm printed after a value, e.g. "ALARM" or
+	// "ALARM (CRIT)".
+	alarm = regexp.MustCompile(`\bALARM\b(?:\s*\((\w+)\))?`)
+)
+
+// Parse takes stdout from the sensors command and returns its readings with
+// the thresholds and alarms that Format throws away. Annotations continued on
+// the following line are attached to the reading above them. Values which can
+// not be parsed, such as "N/A", are skipped.
+func Parse(stdout []byte) []report.Reading {
+	readings := make([]report.Reading, 0)
+
+	for _, block := range strings.Split(validUTF8(stdout), "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if len(lines) < 2 {
+			continue
+		}
+		chip := strings.TrimSpace(lines[0])
+		adapter := ""
+		var last *report.Reading
+
+		for _, line := range lines[1:] {
+			if strings.HasPrefix(strings.TrimSpace(line), "(") {
+				if last != nil {
+					annotate(last, line)
+				}
+				continue
+			}
+
+			fields := strings.SplitN(line, ":", 2)
+			if len(fields) < 2 {
+				continue
+			}
+			label, rest := strings.TrimSpace(fields[0]), fields[1]
+			if label == "Adapter" {
+				adapter = strings.TrimSpace(rest)
+				continue
+			}
+
+			last = nil
+			value, kind, err := report.ParseValue(valueOf(rest))
+			if err != nil {
+				value, kind, err = intrusionOf(label, rest)
+			}
+			if err != nil {
+				continue
+			}
+			readings = append(readings, report.Reading{
+				Chip:    chip,
+				Adapter: adapter,
+				Label:   label,
+				Kind:    kind,
+				Value:   value,
+			})
+			last = &readings[len(readings)-1]
+			annotate(last, rest)
+		}
+	}
+
+	return readings
+}
+
+// validUTF8 returns stdout with invalid UTF-8 replaced, so that chips and
+// labels are unchanged by a round trip through JSON.
+func validUTF8(stdout []byte) string {
+	return strings.ToValidUTF8(string(stdout), "\uFFFD")
+}
+
+// FormatReadings takes stdout from the sensors command and returns its
+// readings, including thresholds and alarms, as JSON.
+func FormatReadings(stdout []byte) ([]byte, error) {
+	return json.Marshal(Parse(stdout))
+}
+
+// valueOf returns the value at the start of the text after a label, e.g.
+// "+26.5 C" from "  +26.5 C  (high = +80.0 C, hyst = +75.0 C)" or "-128.0°C"
+// from "-128.0°C    sensor = thermistor", or "" if there is none.
+func valueOf(rest string) string {
+	match := valuePattern.FindStringSubmatch(rest)
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
+}
+
+// intrusionOf parses the state of a chassis intrusion detector, which sensors
+// prints as "ALARM" or "OK" without a unit.
+func intrusionOf(label, rest string) (float64, report.Kind, error) {
+	fields := strings.Fields(rest)
+	if strings.HasPrefix(label, "intrusion") && len(fields) > 0 {
+		switch fields[0] {
+		case "ALARM":
+			return 1, report.KindIntrusion, nil
+		case "OK":
+			return 0, report.KindIntrusion, nil
+		}
+	}
+
+	return 0, report.KindUnknown, fmt.Errorf("classic: can not parse %s: %s", label, rest)
+}
+
+// thresholdNames maps the names sensors prints for thresholds, with spaces
+// replaced by underscores, to the names of the subfeatures they are read
+// from, which are used by ParseRaw. A bare hyst follows the threshold before
+// it, see annotate.
+var thresholdNames = map[string]string{
+	"low":           "min",
+	"high":          "max",
+	"crit_low":      "lcrit",
+	"crit_low_hyst": "lcrit_hyst",
+	"crit_min":      "lcrit",
+	"crit_max":      "crit",
+	"crit_hyst":     "crit_hyst",
+	"emerg":         "emergency",
+	"emerg_hyst":    "emergency_hyst",
+	"lowest":        "lowest",
+	"highest":       "highest",
+}
+
+// annotate adds the thresholds and alarms printed in text to r. Thresholds are
+// named as ParseRaw names them, so a hysteresis is named after the threshold
+// it follows, e.g. "(high = +80.0 C, hyst = +75.0 C)" is max and max_hyst.
+func annotate(r *report.Reading, text string) {
+	for _, match := range annotation.FindAllStringSubmatch(text, -1) {
+		previous := "max"
+		for _, pair := range strings.Split(match[1], ",") {
+			fields := strings.SplitN(pair, "=", 2)
+			if len(fields) < 2 {
+				continue
+			}
+			name := strings.Join(strings.Fields(fields[0]), "_")
+			if canonical, ok := thresholdNames[name]; ok {
+				name = canonical
+			}
+			if name == "hyst" {
+				name = previous + "_hyst"
+			} else {
+				previous = name
+			}
+
+			value, _, err := report.ParseValue(strings.TrimSpace(fields[1]))
+			if err != nil {
+				continue
+			}
+			if r.Thresholds == nil {
+				r.Thresholds = make(map[string]float64)
+			}
+			r.Thresholds[name] = value
+		}
+	}
+
+	for _, match := range alarm.FindAllStringSubmatch(text, -1) {
+		name := "alarm"
+		if match[1] != "" {
+			name = strings.ToLower(match[1]) + "_alarm"
+		}
+		if r.Alarms == nil {
+			r.Alarms = make(map[string]bool)
+		}
+		r.Alarms[name] = true
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classic
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"experimental/dwat/gosense/pkg/cache"
+	"experimental/dwat/gosense/pkg/report"
+)
+
+// rawKinds maps the prefix of a feature printed by sensors -u to its kind, in
+// the order they must be tried since "intrusion" and "in" share a prefix.
+var rawKinds = []struct {
+	prefix string
+	kind   report.Kind
+}{
+	{"temp", report.KindTemperature},
+	{"intrusion", report.KindIntrusion},
+	{"in", report.KindVoltage},
+	{"curr", report.KindCurrent},
+	{"power", report.KindPower},
+	{"energy", report.KindEnergy},
+	{"fan", report.KindFan},
+	{"humidity", report.KindHumidity},
+}
+
+// rawThresholds are the subfeatures printed by sensors -u which are
+// thresholds, or the lowest and highest values seen, which Parse reports with
+// them. Each may also have a hysteresis, e.g. max_hyst.
+var rawThresholds = map[string]bool{
+	"min":       true,
+	"max":       true,
+	"lcrit":     true,
+	"crit":      true,
+	"emergency": true,
+	"cap":       true,
+	"lowest":    true,
+	"highest":   true,
+}
+
+// rawCommand returns the command printing raw values.
+func rawCommand() cache.Command {
+	c := command()
+	c.Args = append([]string{"-u"}, c.Args...)
+	return c
+}
+
+// UpdateRaw runs sensors -u and renders its readings, including thresholds and
+// alarms.
+func UpdateRaw() ([]byte, error) {
+	output, err := cache.RunCommand(rawCommand())
+
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	return json.Marshal(ParseRaw(output))
+}
+
+// feature is a labelled feature of a chip printed by sensors -u, e.g.
+//
+//	Core 0:
+//	  temp2_input: 43.000
+//	  temp2_max: 80.000
+type feature struct {
+	label       string
+	name        string             // name is the feature, e.g. temp2
+	subfeatures map[string]float64 // subfeatures by name, e.g. input or max
+}
+
+// ParseRaw takes stdout from sensors -u and returns its readings. Unlike the
+// classic format every value is printed in the base unit of its kind, each
+// label is on a line of its own so it may contain colons, and chips are
+// separated by blank lines. Features without a value, such as those which
+// could not be read, are skipped.
+func ParseRaw(stdout []byte) []report.Reading {
+	readings := make([]report.Reading, 0)
+	chip, adapter := "", ""
+	var f *feature
+
+	flush := func() {
+		if f != nil {
+			if r, ok := f.reading(chip, adapter); ok {
+				readings = append(readings, r)
+			}
+		}
+		f = nil
+	}
+
+	for _, line := range strings.Split(validUTF8(stdout), "\n") {
+		trimmed := strings.TrimSpace(line)
+		indented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+
+		switch {
+		case trimmed == "":
+			flush()
+			chip, adapter = "", ""
+		case chip == "":
+			chip = trimmed
+		case !indented && strings.HasPrefix(trimmed, "Adapter:"):
+			adapter = strings.TrimSpace(strings.TrimPrefix(trimmed, "Adapter:"))
+		case !indented && strings.HasSuffix(trimmed, ":"):
+			flush()
+			f = &feature{label: strings.TrimSuffix(trimmed, ":"), subfeatures: make(map[string]float64)}
+		case indented && f != nil:
+			fields := strings.SplitN(trimmed, ":", 2)
+			if len(fields) < 2 {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			name := strings.SplitN(fields[0], "_", 2)
+			if len(name) < 2 {
+				continue
+			}
+			f.name = name[0]
+			f.subfeatures[name[1]] = value
+		}
+	}
+	flush()
+
+	return readings
+}
+
+// reading returns the reading of the feature on chip, or false if it has no
+// value.
+func (f *feature) reading(chip, adapter string) (report.Reading, bool) {
+	r := report.Reading{Chip: chip, Adapter: adapter, Label: f.label, Kind: report.KindUnknown}
+	for _, k := range rawKinds {
+		if strings.HasPrefix(f.name, k.prefix) {
+			r.Kind = k.kind
+			break
+		}
+	}
+
+	// A faulty feature is reported even if its input could not be read, as
+	// its value is meaningless anyway.
+	faulty := f.subfeatures["fault"] != 0
+	var ok bool
+	switch r.Kind {
+	case report.KindUnknown:
+		return r, false
+	case report.KindIntrusion:
+		r.Value, ok = f.subfeatures["alarm"]
+	case report.KindPower:
+		if r.Value, ok = f.subfeatures["input"]; !ok {
+			r.Value, ok = f.subfeatures["average"]
+		}
+	default:
+		r.Value, ok = f.subfeatures["input"]
+	}
+	if !ok && !faulty {
+		return r, false
+	}
+
+	for name, value := range f.subfeatures {
+		switch {
+		case rawThresholds[strings.TrimSuffix(name, "_hyst")]:
+			if r.Thresholds == nil {
+				r.Thresholds = make(map[string]float64)
+			}
+			r.Thresholds[name] = value
+		case name == "alarm" || strings.HasSuffix(name, "_alarm"):
+			if r.Alarms == nil {
+				r.Alarms = make(map[string]bool)
+			}
+			r.Alarms[name] = value != 0
+		case name == "beep":
+			r.Beep = value != 0
+		}
+	}
+	if faulty {
+		r.Value = 0
+		r.Invalid = true
+	}
+
+	return r, true
+}
@@ -0,0 +1,208 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classic_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"experimental/dwat/gosense/pkg/cache"
+	"experimental/dwat/gosense/pkg/lmsensors/classic"
+	"experimental/dwat/gosense/pkg/report"
+)
+
+// TestParseRawMatchesParse tests that the raw and classic output of sensors on
+// a wedge100 are parsed to the same readings.
+func TestParseRawMatchesParse(t *testing.T) {
+	observed := classic.ParseRaw(readTestdata(t, "wedge100", "sensors-u.txt"))
+	expected := classic.Parse(readTestdata(t, "wedge100", "sensors.txt"))
+	if !reflect.DeepEqual(expected, observed) {
+		t.Errorf("Raw readings observed \n%+v\n, expected \n%+v\n", observed, expected)
+	}
+}
+
+// TestParseRawClassic tests that the classic format derived from sensors -u on
+// a wedge100 is the classic format of sensors.
+func TestParseRawClassic(t *testing.T) {
+	observed := readTestdata(t, "wedge100", "classic-u.json")
+	expected := readTestdata(t, "wedge100", "classic.json")
+	if !bytes.Equal(expected, observed) {
+		t.Errorf("Derived classic format observed \n%s\n, expected \n%s\n", observed, expected)
+	}
+}
+
+// TestParseJSON tests that sensors -j is parsed to the same readings as
+// sensors -u.
+func TestParseJSON(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "x86", "sensors-u.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read sensors output %v", err)
+	}
+	encoded, err := os.ReadFile(filepath.Join("testdata", "x86", "sensors_-j.json"))
+	if err != nil {
+		t.Fatalf("Failed to read sensors -j fixture %v", err)
+	}
+	var fixture cache.Fixture
+	if err := json.Unmarshal(encoded, &fixture); err != nil {
+		t.Fatalf("Failed to unmarshal fixture %v", err)
+	}
+
+	observed, err := classic.ParseJSON([]byte(fixture.Stdout))
+	if err != nil {
+		t.Fatalf("ParseJSON failed %v", err)
+	}
+	expected := classic.ParseRaw(raw)
+	if !reflect.DeepEqual(expected, observed) {
+		t.Errorf("JSON readings observed \n%+v\n, expected \n%+v\n", observed, expected)
+	}
+
+	for _, invalid := range []string{"", "[]", `{"chip": 1}`, `{"chip": {"label": {"temp1_input": 1}`} {
+		if _, err := classic.ParseJSON([]byte(invalid)); err == nil {
+			t.Errorf("ParseJSON(%q) observed nil error", invalid)
+		}
+	}
+}
+
+// TestAuto tests that sensors -j is used unless sensors rejects the flag or
+// is older than 3.5.0, and that failures which do not tell, such as a missing
+// fixture, leave the choice to the next update.
+func TestAuto(t *testing.T) {
+	defer cache.SetFixtures(cache.FixtureOff, "")
+
+	// sensors -j and sensors -u were captured on the same x86 server, and
+	// print the same readings.
+	cache.SetFixtures(cache.FixtureReplay, "testdata/x86")
+	auto := new(classic.Auto)
+	for i := 0; i < 2; i++ {
+		output, err := auto.Update()
+		if err != nil {
+			t.Fatalf("Update failed %v", err)
+		}
+		readings, err := classic.Decode(output)
+		if expected := classic.ParseRaw(readTestdata(t, "x86", "sensors-u.txt")); err != nil || !reflect.DeepEqual(readings, expected) {
+			t.Errorf("Decode observed %+v %v, expected the readings of sensors -u", readings, err)
+		}
+	}
+
+	// The wedge100 runs an older sensors, which is read with thresholds.
+	sensors := readTestdata(t, "wedge100", "sensors.json")
+	expected := classic.Parse(readTestdata(t, "wedge100", "sensors.txt"))
+	rejected := cache.Fixture{Command: "sensors", Args: []string{"-j"}, Stderr: "sensors: invalid option -- 'j'\n", ExitCode: 1}
+	failed := cache.Fixture{Command: "sensors", Args: []string{"-j"}, ExitCode: 1}
+	older := cache.Fixture{Command: "sensors", Args: []string{"-v"}, Stdout: "sensors version 3.4.0 with libsensors version 3.4.0\n"}
+	var testsTable = []struct {
+		name     string
+		fixtures []cache.Fixture
+	}{
+		{name: "rejected flag", fixtures: []cache.Fixture{rejected}},
+		{name: "older version", fixtures: []cache.Fixture{failed, older}},
+	}
+
+	for _, tt := range testsTable {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "sensors.json"), sensors, 0644); err != nil {
+				t.Fatalf("Failed to write fixture %v", err)
+			}
+			for _, fixture := range tt.fixtures {
+				writeFixture(t, dir, fixture)
+			}
+			cache.SetFixtures(cache.FixtureReplay, dir)
+
+			output, err := new(classic.Auto).Update()
+			if err != nil {
+				t.Fatalf("Update failed %v", err)
+			}
+			readings, err := classic.Decode(output)
+			if err != nil || !reflect.DeepEqual(readings, expected) {
+				t.Errorf("Decode observed %+v %v, expected the readings of sensors", readings, err)
+			}
+		})
+	}
+
+	t.Run("undecided", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "sensors.json"), sensors, 0644); err != nil {
+			t.Fatalf("Failed to write fixture %v", err)
+		}
+		cache.SetFixtures(cache.FixtureReplay, dir)
+
+		// Without fixtures for sensors -j and sensors -v it is not known
+		// whether -j is supported.
+		auto := new(classic.Auto)
+		if _, err := auto.Update(); cache.Classify(err) != cache.KindFixture {
+			t.Fatalf("Update observed %v, expected a fixture error", err)
+		}
+
+		writeFixture(t, dir, rejected)
+		output, err := auto.Update()
+		if err != nil {
+			t.Fatalf("Update failed %v", err)
+		}
+		readings, err := classic.Decode(output)
+		if err != nil || !reflect.DeepEqual(readings, expected) {
+			t.Errorf("Decode observed %+v %v, expected the readings of sensors", readings, err)
+		}
+	})
+}
+
+// writeFixture writes fixture to dir, where replayed commands look for it.
+func writeFixture(t *testing.T, dir string, fixture cache.Fixture) {
+	t.Helper()
+
+	encoded, err := json.Marshal(fixture)
+	if err != nil {
+		t.Fatalf("Failed to marshal fixture %v", err)
+	}
+	path := cache.FixturePath(dir, cache.Command{Command: fixture.Command, Args: fixture.Args})
+	if err := os.WriteFile(path, encoded, 0644); err != nil {
+		t.Fatalf("Failed to write fixture %v", err)
+	}
+}
+
+// TestParseRawLabels tests labels containing colons, features which could not
+// be read and features which are faulty, with or without an input.
+func TestParseRawLabels(t *testing.T) {
+	output := []byte(`nvme-pci-0100
+Adapter: PCI adapter
+Composite: NVMe:
+  temp1_input: 31.850
+  temp1_crit: 84.850
+Sensor 1:
+  temp2_input: N/A
+Sensor 2:
+  temp3_input: -273.150
+  temp3_fault: 1.000
+Sensor 3:
+  temp4_crit: 84.850
+  temp4_fault: 1.000
+`)
+
+	expected := []report.Reading{
+		{Chip: "nvme-pci-0100", Adapter: "PCI adapter", Label: "Composite: NVMe", Kind: report.KindTemperature, Value: 31.85,
+			Thresholds: map[string]float64{"crit": 84.85}},
+		{Chip: "nvme-pci-0100", Adapter: "PCI adapter", Label: "Sensor 2", Kind: report.KindTemperature, Invalid: true},
+		{Chip: "nvme-pci-0100", Adapter: "PCI adapter", Label: "Sensor 3", Kind: report.KindTemperature, Invalid: true,
+			Thresholds: map[string]float64{"crit": 84.85}},
+	}
+	observed := classic.ParseRaw(output)
+	if !reflect.DeepEqual(expected, observed) {
+		t.Errorf("Raw readings observed %+v, expected %+v", observed, expected)
+	}
+}
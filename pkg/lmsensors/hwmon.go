@@ -0,0 +1,330 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lmsensors
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"experimental/dwat/gosense/pkg/cache"
+	"experimental/dwat/gosense/pkg/report"
+)
+
+// channelKinds are the types of hwmon channels in the order sensors prints
+// them, with their kind and the ratio converting their sysfs values to the
+// base unit of the kind, see
+// https://www.kernel.org/doc/Documentation/hwmon/sysfs-interface
+// Values are divided rather than multiplied by a fraction, so that e.g. 904
+// millivolts is exactly 0.904 volts.
+var channelKinds = []struct {
+	prefix     string
+	kind       report.Kind
+	multiplier float64
+	divisor    float64
+}{
+	{"in", report.KindVoltage, 1, 1e3},        // millivolts
+	{"fan", report.KindFan, 1, 1},             // revolutions per minute
+	{"pwm", report.KindPWM, 100, 255},         // 0 to 255
+	{"temp", report.KindTemperature, 1, 1e3},  // millidegrees Celsius
+	{"power", report.KindPower, 1, 1e6},       // microwatts
+	{"energy", report.KindEnergy, 1, 1e6},     // microjoules
+	{"curr", report.KindCurrent, 1, 1e3},      // milliamperes
+	{"humidity", report.KindHumidity, 1, 1e3}, // milli-percent
+	{"intrusion", report.KindIntrusion, 1, 1},
+}
+
+// channelThresholds are the attributes of a channel holding thresholds, named
+// as sensors -u names them. Each may also have a hysteresis, e.g. max_hyst.
+var channelThresholds = map[string]bool{
+	"min":       true,
+	"max":       true,
+	"lcrit":     true,
+	"crit":      true,
+	"emergency": true,
+	"cap":       true,
+}
+
+// attributePattern matches the name of a channel attribute, e.g. temp1_input
+// or pwm1, whose value has no suffix.
+var attributePattern = regexp.MustCompile(`^([a-z]+)([0-9]+)(?:_([a-z_]+))?$`)
+
+// channel is a numbered channel of a chip, e.g. temp1.
+type channel struct {
+	kind       int               // kind indexes channelKinds
+	index      int               // index is the number of the channel
+	name       string            // name is the channel, e.g. temp1
+	attributes []string          // attributes of the channel, e.g. input for temp1_input
+	files      map[string]string // files are the attributes of the chip, see chip
+}
+
+// chip is a hwmon chip and the contents of its files.
+type chip struct {
+	name  string            // name is the numbered name of the chip, e.g. coretemp-00
+	files map[string]string // files are the files in the directory of the chip by name
+	// raw holds the files in the directory of the chip and below, grouped
+	// by what precedes the first underscore of their name, as the library
+	// grouped them, e.g. raw["temp1"]["crit_alarm"] for temp1_crit_alarm.
+	raw map[string]map[string]string
+}
+
+// Scan reads every hwmon chip of the sysfs mounted at root, usually /sys.
+// Chips are named after their name attribute and numbered in the order of
+// their hwmon device names, e.g. coretemp-00, as the library gosense used to
+// depend on named them. The adapter of each chip is not reported.
+func Scan(root string) ([]report.Reading, error) {
+	chips, err := scanChips(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return chipReadings(chips), nil
+}
+
+// chipReadings returns the readings of the channels of chips.
+func chipReadings(chips []chip) []report.Reading {
+	readings := make([]report.Reading, 0)
+	for _, c := range chips {
+		for _, ch := range c.channels() {
+			if r, ok := ch.reading(c.name); ok {
+				readings = append(readings, r)
+			}
+		}
+	}
+
+	return readings
+}
+
+// scanChips reads the files of every hwmon chip of the sysfs mounted at root.
+// The hwmon devices are taken in lexical order, e.g. hwmon10 before hwmon2,
+// as the library walked them.
+func scanChips(root string) ([]chip, error) {
+	class := filepath.Join(root, "class", "hwmon")
+	entries, err := os.ReadDir(class)
+	if err != nil {
+		return nil, &cache.SysfsError{Err: err}
+	}
+
+	chips := make([]chip, 0, len(entries))
+	counts := make(map[string]int)
+	for _, e := range entries {
+		dir := filepath.Join(class, e.Name())
+		name, err := readAttribute(dir, "name")
+		if err != nil {
+			// Older drivers keep the attributes of the chip in its device.
+			dir = filepath.Join(dir, "device")
+			if name, err = readAttribute(dir, "name"); err != nil {
+				continue
+			}
+		}
+
+		c, err := scanChip(dir)
+		if err != nil {
+			return nil, &cache.SysfsError{Err: err}
+		}
+		c.name = fmt.Sprintf("%s-%02d", name, counts[name])
+		counts[name]++
+		chips = append(chips, c)
+	}
+
+	return chips, nil
+}
+
+// scanChip reads every regular file in dir and below, as the library did,
+// except the files which hold no sensor data. Files which can not be read are
+// skipped.
+func scanChip(dir string) (chip, error) {
+	c := chip{files: make(map[string]string), raw: make(map[string]map[string]string)}
+	// dir is reached through links, which a walk does not follow.
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return c, err
+	}
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || skipFile(info.Name()) {
+			return nil
+		}
+		value, err := readAttribute(filepath.Dir(path), info.Name())
+		if err != nil {
+			return nil
+		}
+
+		if filepath.Dir(path) == dir {
+			c.files[info.Name()] = value
+		}
+		if fields := strings.SplitN(info.Name(), "_", 2); len(fields) == 2 {
+			if c.raw[fields[0]] == nil {
+				c.raw[fields[0]] = make(map[string]string)
+			}
+			c.raw[fields[0]][fields[1]] = value
+		}
+		return nil
+	})
+
+	return c, err
+}
+
+// skipFile returns whether the file called name holds no sensor data, such as
+// the runtime power management attributes.
+func skipFile(name string) bool {
+	switch name {
+	case "async", "autosuspend_delay_ms", "control", "driver_override", "modalias", "uevent":
+		return true
+	}
+
+	return strings.HasPrefix(name, "runtime_")
+}
+
+// channels returns the channels of the chip in the order sensors prints them.
+func (c chip) channels() []channel {
+	seen := make(map[string]int)
+	channels := make([]channel, 0)
+	for file := range c.files {
+		match := attributePattern.FindStringSubmatch(file)
+		if match == nil {
+			continue
+		}
+		name := match[1] + match[2]
+		if i, ok := seen[name]; ok {
+			channels[i].attributes = append(channels[i].attributes, match[3])
+			continue
+		}
+		for kind, k := range channelKinds {
+			if k.prefix == match[1] {
+				index, _ := strconv.Atoi(match[2])
+				seen[name] = len(channels)
+				channels = append(channels, channel{kind: kind, index: index, name: name, attributes: []string{match[3]}, files: c.files})
+				break
+			}
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		if channels[i].kind != channels[j].kind {
+			return channels[i].kind < channels[j].kind
+		}
+		return channels[i].index < channels[j].index
+	})
+
+	return channels
+}
+
+// reading returns the reading of the channel on chip, with its thresholds,
+// alarm flags and beep flag, or false if it has no value, e.g. because the
+// sensor is not connected. The value of a channel which the chip reports as
+// faulty is not read, and the reading is invalid instead.
+func (c channel) reading(chip string) (report.Reading, bool) {
+	k := channelKinds[c.kind]
+	r := report.Reading{Chip: chip, Label: c.name, Channel: c.name, Kind: k.kind}
+	if label := c.files[c.name+"_label"]; label != "" {
+		r.Label = label
+	}
+
+	var err error
+	switch {
+	case c.flag("fault"):
+		r.Invalid = true
+	case k.kind == report.KindPWM:
+		r.Value, err = c.value("")
+	case k.kind == report.KindIntrusion:
+		r.Value, err = c.value("alarm")
+	case k.kind == report.KindPower:
+		if r.Value, err = c.value("input"); err != nil {
+			r.Value, err = c.value("average")
+		}
+	default:
+		r.Value, err = c.value("input")
+	}
+	if err != nil {
+		return r, false
+	}
+	r.Value = c.convert(r.Value)
+
+	for _, attribute := range c.attributes {
+		switch {
+		case channelThresholds[strings.TrimSuffix(attribute, "_hyst")]:
+			if value, err := c.value(attribute); err == nil {
+				if r.Thresholds == nil {
+					r.Thresholds = make(map[string]float64)
+				}
+				r.Thresholds[attribute] = c.convert(value)
+			}
+		case attribute == "alarm" || strings.HasSuffix(attribute, "_alarm"):
+			if value, err := c.value(attribute); err == nil {
+				if r.Alarms == nil {
+					r.Alarms = make(map[string]bool)
+				}
+				r.Alarms[attribute] = value != 0
+			}
+		case attribute == "beep":
+			r.Beep = c.flag(attribute)
+		}
+	}
+
+	return r, true
+}
+
+// convert returns the sysfs value of the channel in the base unit of its kind.
+func (c channel) convert(value float64) float64 {
+	k := channelKinds[c.kind]
+	return value * k.multiplier / k.divisor
+}
+
+// flag returns whether the attribute of the channel is set, e.g. fault for
+// temp1_fault.
+func (c channel) flag(attribute string) bool {
+	value, err := c.value(attribute)
+	return err == nil && value != 0
+}
+
+// value returns the numeric attribute of the channel, e.g. input for
+// temp1_input, or the attribute named after the channel if attribute is empty.
+// Values which are not finite are rejected, since they can not be encoded as
+// JSON.
+func (c channel) value(attribute string) (float64, error) {
+	name := c.name
+	if attribute != "" {
+		name += "_" + attribute
+	}
+	s, ok := c.files[name]
+	if !ok {
+		return 0, fmt.Errorf("lmsensors: %s not found", name)
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err == nil && (math.IsNaN(value) || math.IsInf(value, 0)) {
+		err = fmt.Errorf("lmsensors: %s is not finite", name)
+	}
+
+	return value, err
+}
+
+// readAttribute returns the trimmed contents of the attribute name in dir.
+func readAttribute(dir, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
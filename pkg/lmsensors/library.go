@@ -0,0 +1,365 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lmsensors
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"experimental/dwat/gosense/pkg/report"
+)
+
+// device is a chip as the lmsensors library gosense used to depend on
+// returned it, whose sensors are of the types below.
+type device struct {
+	Name    string
+	Sensors []interface{}
+}
+
+// sensor is implemented by the sensor types below, which have the fields of
+// their counterparts in the library, in the same order, so that they are
+// encoded the same.
+type sensor interface {
+	// parse sets the fields of the sensor from its raw attributes, e.g.
+	// input for temp1_input, as the library did.
+	parse(raw map[string]string)
+	// reading returns the sensor on chip as a reading, so that it can be
+	// rewritten.
+	reading(chip string) report.Reading
+	// apply sets the fields of the sensor from a rewritten reading.
+	apply(r report.Reading)
+}
+
+// newSensor returns an empty sensor of the type the library chose for the
+// channel called name, or nil if the library skipped it. Note that the
+// library checked the prefixes in this order, so e.g. intrusion0 is not a
+// voltage.
+func newSensor(name string) sensor {
+	switch {
+	case strings.HasPrefix(name, "curr"):
+		return &currentSensor{Name: name}
+	case strings.HasPrefix(name, "intrusion"):
+		return &intrusionSensor{Name: name}
+	case strings.HasPrefix(name, "in"):
+		return &voltageSensor{Name: name}
+	case strings.HasPrefix(name, "fan"):
+		return &fanSensor{Name: name}
+	case strings.HasPrefix(name, "power"):
+		return &powerSensor{Name: name}
+	case strings.HasPrefix(name, "temp"):
+		return &temperatureSensor{Name: name}
+	}
+
+	return nil
+}
+
+// device returns the chip as the library returned it, with a sensor for every
+// group of raw attributes the library had a sensor type for, sorted by name.
+// Unlike the library, which failed the whole scan, attributes which can not
+// be parsed, or are not finite and so can not be encoded, are left zero.
+func (c chip) device() device {
+	names := make([]string, 0, len(c.raw))
+	for name := range c.raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	d := device{Name: c.name, Sensors: make([]interface{}, 0, len(names))}
+	for _, name := range names {
+		if s := newSensor(name); s != nil {
+			s.parse(c.raw[name])
+			d.Sensors = append(d.Sensors, s)
+		}
+	}
+
+	return d
+}
+
+// rawDevice is a device whose sensors are left encoded.
+type rawDevice struct {
+	Name    string
+	Sensors []json.RawMessage
+}
+
+// rewriteDevices applies fn to each sensor of the devices encoded in data on
+// its own, as a reading, and returns the devices with the sensors fn returns.
+// Sensors fn leaves unchanged are kept byte for byte. Devices left with no
+// sensors are dropped unless keepEmpty is set.
+func rewriteDevices(data []byte, fn func([]report.Reading) []report.Reading, keepEmpty bool) ([]byte, error) {
+	var devices []rawDevice
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, err
+	}
+	if devices == nil {
+		return data, nil
+	}
+
+	rewritten := make([]rawDevice, 0, len(devices))
+	for _, d := range devices {
+		sensors := make([]json.RawMessage, 0, len(d.Sensors))
+		for _, encoded := range d.Sensors {
+			var named struct{ Name string }
+			if err := json.Unmarshal(encoded, &named); err != nil {
+				return nil, err
+			}
+			s := newSensor(named.Name)
+			if s == nil {
+				sensors = append(sensors, encoded)
+				continue
+			}
+			if err := json.Unmarshal(encoded, s); err != nil {
+				return nil, err
+			}
+
+			r := s.reading(d.Name)
+			for _, w := range fn([]report.Reading{r}) {
+				if reflect.DeepEqual(w, r) {
+					sensors = append(sensors, encoded)
+					continue
+				}
+				s.apply(w)
+				reencoded, err := json.Marshal(s)
+				if err != nil {
+					return nil, err
+				}
+				sensors = append(sensors, reencoded)
+			}
+		}
+		if len(sensors) > 0 || keepEmpty {
+			rewritten = append(rewritten, rawDevice{Name: d.Name, Sensors: sensors})
+		}
+	}
+
+	return json.Marshal(rewritten)
+}
+
+type temperatureSensor struct {
+	Name          string
+	Label         string
+	Alarm         bool
+	Beep          bool
+	Type          int
+	Input         float64
+	High          float64
+	Critical      float64
+	CriticalAlarm bool
+}
+
+func (s *temperatureSensor) parse(raw map[string]string) {
+	s.Label = raw["label"]
+	s.Alarm = flag(raw, "alarm")
+	s.Beep = flag(raw, "beep")
+	s.Type, _ = strconv.Atoi(raw["type"])
+	s.Input = scaled(raw, "input", 1e3)
+	s.High = scaled(raw, "max", 1e3)
+	s.Critical = scaled(raw, "crit", 1e3)
+	s.CriticalAlarm = flag(raw, "crit_alarm")
+}
+
+func (s *temperatureSensor) reading(chip string) report.Reading {
+	return report.Reading{Chip: chip, Label: label(s.Label, s.Name), Channel: s.Name, Kind: report.KindTemperature, Value: s.Input,
+		Thresholds: thresholds(map[string]float64{"max": s.High, "crit": s.Critical}), Alarms: map[string]bool{"alarm": s.Alarm, "crit_alarm": s.CriticalAlarm}, Beep: s.Beep}
+}
+
+func (s *temperatureSensor) apply(r report.Reading) {
+	relabel(&s.Label, s.Name, r.Label)
+	s.Input, s.High, s.Critical = r.Value, r.Thresholds["max"], r.Thresholds["crit"]
+}
+
+type voltageSensor struct {
+	Name    string
+	Label   string
+	Alarm   bool
+	Beep    bool
+	Input   float64
+	Maximum float64
+}
+
+func (s *voltageSensor) parse(raw map[string]string) {
+	s.Label = raw["label"]
+	s.Alarm = flag(raw, "alarm")
+	s.Beep = flag(raw, "beep")
+	s.Input = scaled(raw, "input", 1e3)
+	s.Maximum = scaled(raw, "max", 1e3)
+}
+
+func (s *voltageSensor) reading(chip string) report.Reading {
+	return report.Reading{Chip: chip, Label: label(s.Label, s.Name), Channel: s.Name, Kind: report.KindVoltage, Value: s.Input,
+		Thresholds: thresholds(map[string]float64{"max": s.Maximum}), Alarms: map[string]bool{"alarm": s.Alarm}, Beep: s.Beep}
+}
+
+func (s *voltageSensor) apply(r report.Reading) {
+	relabel(&s.Label, s.Name, r.Label)
+	s.Input, s.Maximum = r.Value, r.Thresholds["max"]
+}
+
+type fanSensor struct {
+	Name    string
+	Alarm   bool
+	Beep    bool
+	Input   int
+	Minimum int
+}
+
+func (s *fanSensor) parse(raw map[string]string) {
+	s.Alarm = flag(raw, "alarm")
+	s.Beep = flag(raw, "beep")
+	s.Input, _ = strconv.Atoi(raw["input"])
+	s.Minimum, _ = strconv.Atoi(raw["min"])
+}
+
+func (s *fanSensor) reading(chip string) report.Reading {
+	return report.Reading{Chip: chip, Label: s.Name, Channel: s.Name, Kind: report.KindFan, Value: float64(s.Input),
+		Thresholds: thresholds(map[string]float64{"min": float64(s.Minimum)}), Alarms: map[string]bool{"alarm": s.Alarm}, Beep: s.Beep}
+}
+
+func (s *fanSensor) apply(r report.Reading) {
+	s.Input, s.Minimum = int(math.Round(r.Value)), int(math.Round(r.Thresholds["min"]))
+}
+
+type currentSensor struct {
+	Name     string
+	Label    string
+	Alarm    bool
+	Input    float64
+	Maximum  float64
+	Critical float64
+}
+
+func (s *currentSensor) parse(raw map[string]string) {
+	s.Label = raw["label"]
+	s.Alarm = flag(raw, "alarm")
+	s.Input = scaled(raw, "input", 1e3)
+	s.Maximum = scaled(raw, "max", 1e3)
+	s.Critical = scaled(raw, "crit", 1e3)
+}
+
+func (s *currentSensor) reading(chip string) report.Reading {
+	return report.Reading{Chip: chip, Label: label(s.Label, s.Name), Channel: s.Name, Kind: report.KindCurrent, Value: s.Input,
+		Thresholds: thresholds(map[string]float64{"max": s.Maximum, "crit": s.Critical}), Alarms: map[string]bool{"alarm": s.Alarm}}
+}
+
+func (s *currentSensor) apply(r report.Reading) {
+	relabel(&s.Label, s.Name, r.Label)
+	s.Input, s.Maximum, s.Critical = r.Value, r.Thresholds["max"], r.Thresholds["crit"]
+}
+
+type powerSensor struct {
+	Name            string
+	Average         float64
+	AverageInterval time.Duration
+	Battery         bool
+	ModelNumber     string
+	OEMInfo         string
+	SerialNumber    string
+}
+
+func (s *powerSensor) parse(raw map[string]string) {
+	s.Average = scaled(raw, "average", 1e6)
+	if interval, ok := raw["average_interval"]; ok {
+		s.AverageInterval, _ = time.ParseDuration(interval + "ms")
+	}
+	s.Battery = flag(raw, "is_battery")
+	s.ModelNumber = raw["model_number"]
+	s.OEMInfo = raw["oem_info"]
+	s.SerialNumber = raw["serial_number"]
+}
+
+func (s *powerSensor) reading(chip string) report.Reading {
+	return report.Reading{Chip: chip, Label: s.Name, Channel: s.Name, Kind: report.KindPower, Value: s.Average}
+}
+
+func (s *powerSensor) apply(r report.Reading) {
+	s.Average = r.Value
+}
+
+type intrusionSensor struct {
+	Name  string
+	Alarm bool
+}
+
+func (s *intrusionSensor) parse(raw map[string]string) {
+	s.Alarm = flag(raw, "alarm")
+}
+
+func (s *intrusionSensor) reading(chip string) report.Reading {
+	value := 0.0
+	if s.Alarm {
+		value = 1
+	}
+
+	return report.Reading{Chip: chip, Label: s.Name, Channel: s.Name, Kind: report.KindIntrusion, Value: value}
+}
+
+func (s *intrusionSensor) apply(r report.Reading) {
+	s.Alarm = r.Value != 0
+}
+
+// flag returns whether the raw attribute is set, which the library took to
+// be anything but "0" if the attribute exists.
+func flag(raw map[string]string, attribute string) bool {
+	value, ok := raw[attribute]
+	return ok && value != "0"
+}
+
+// scaled returns the raw attribute divided by divisor, or 0 if it is missing,
+// can not be parsed or is not finite.
+func scaled(raw map[string]string, attribute string, divisor float64) float64 {
+	value, err := strconv.ParseFloat(raw[attribute], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0
+	}
+
+	return value / divisor
+}
+
+// label returns the label of a sensor as a reading is labelled, which is the
+// channel unless the chip reports a label.
+func label(label, channel string) string {
+	if label == "" {
+		return channel
+	}
+
+	return label
+}
+
+// relabel sets the label of a sensor on channel to the label of a rewritten
+// reading, leaving it empty if the reading is still labelled with the channel.
+func relabel(label *string, channel, rewritten string) {
+	if *label != "" || rewritten != channel {
+		*label = rewritten
+	}
+}
+
+// thresholds returns the thresholds which are set, or nil if there are none.
+// The library left thresholds the chip does not have zero, so zero is taken
+// to be unset.
+func thresholds(values map[string]float64) map[string]float64 {
+	for name, value := range values {
+		if value == 0 {
+			delete(values, name)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	return values
+}
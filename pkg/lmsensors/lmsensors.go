@@ -3,22 +3,113 @@ package lmsensors
 import (
 	"encoding/json"
 
-	"github.com/mdlayher/lmsensors"
+	"experimental/dwat/gosense/pkg/report"
 )
 
-// Update queries Linux Monitoring Sensors (lmsensors) by traversing sysfs.
-// We think the lmsensors library is relatively safe because we do not expect
-// sysfs reads to block.
+// Root is where sysfs is mounted, e.g. /host/sys in a container which mounts
+// the sysfs of the host there. It must be set before the first update.
+var Root = "/sys"
+
+// Update queries Linux Monitoring Sensors (lmsensors) by traversing sysfs,
+// and renders every hwmon chip as the lmsensors library gosense used to
+// depend on did. We think reading sysfs is relatively safe because we do not
+// expect sysfs reads to block.
 func Update() ([]byte, error) {
-	encoded := make([]byte, 0)
-	scanner := lmsensors.New()
-	data, err := scanner.Scan()
+	chips, err := scanChips(Root)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	return json.Marshal(devices(chips))
+}
+
+// UpdateAll is Update, but also renders the readings of every hwmon chip in
+// the normalized schema, with every threshold and alarm, as
+//
+//	{"devices": <the data of Update>, "readings": [<reading>, ...]}
+//
+// so that the devices are served as the native format and the readings as
+// every other format, see Decode and FormatDevices.
+func UpdateAll() ([]byte, error) {
+	chips, err := scanChips(Root)
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	encoded, err := json.Marshal(devices(chips))
+	if err != nil {
+		return []byte(nil), err
+	}
+	readings, err := json.Marshal(chipReadings(chips))
+	if err != nil {
+		return []byte(nil), err
+	}
+
+	return json.Marshal(cached{Devices: encoded, Readings: readings})
+}
+
+// cached is the data returned by UpdateAll.
+type cached struct {
+	Devices  json.RawMessage `json:"devices"`
+	Readings json.RawMessage `json:"readings"`
+}
+
+// devices returns chips as the library returned them. The library returned
+// null rather than an empty array if there were no chips.
+func devices(chips []chip) []device {
+	var devices []device
+	for _, c := range chips {
+		devices = append(devices, c.device())
+	}
+
+	return devices
+}
+
+// Decode converts the data returned by UpdateAll to readings.
+func Decode(data []byte) ([]report.Reading, error) {
+	var d cached
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	var readings []report.Reading
+	if err := json.Unmarshal(d.Readings, &readings); err != nil {
+		return nil, err
+	}
+
+	return readings, nil
+}
+
+// FormatDevices returns the devices in the data returned by UpdateAll, which
+// are the native format of the sensors monitor, with only the sensors selected
+// by filter. Devices left with no sensors are dropped. The library had no
+// sensor type for the pwm, energy and humidity channels, so they are never
+// selected.
+func FormatDevices(data []byte, filter report.Filter) ([]byte, error) {
+	var d cached
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	if filter.Empty() {
+		return d.Devices, nil
+	}
+
+	return rewriteDevices(d.Devices, filter.Apply, false)
+}
+
+// Rewrite is a report.Rewrite for the data returned by UpdateAll, which
+// applies fn to the readings and to each sensor of the devices.
+func Rewrite(data []byte, fn func([]report.Reading) []report.Reading) ([]byte, error) {
+	var d cached
+	err := json.Unmarshal(data, &d)
 	if err == nil {
-		encoded, err = json.Marshal(data)
-		if err != nil {
-			return []byte(nil), err
-		}
+		d.Readings, err = report.RewriteReadings(d.Readings, fn)
+	}
+	if err == nil {
+		d.Devices, err = rewriteDevices(d.Devices, fn, true)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return encoded, err
+	return json.Marshal(d)
 }
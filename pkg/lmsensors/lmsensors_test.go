@@ -1,6 +1,10 @@
 package lmsensors_test
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	// This configures http handlers to serve pprof data at runtime. It also adds ~3 MB
@@ -9,8 +13,310 @@ import (
 
 	"experimental/dwat/gosense/pkg/cache"
 	"experimental/dwat/gosense/pkg/lmsensors"
+	"experimental/dwat/gosense/pkg/report"
+	"experimental/dwat/gosense/pkg/sysfstest"
 )
 
+// TestScan tests Scan against a sysfs with chips in both the hwmon device and
+// its parent device, as older drivers do. Chips are numbered in the lexical
+// order of their hwmon devices, so hwmon10 comes before hwmon2.
+func TestScan(t *testing.T) {
+	root := sysfstest.New(t,
+		sysfstest.Chip{Index: 0, Device: "platform/coretemp.0", Name: "coretemp", Attributes: map[string]string{
+			"temp1_input":      "42000",
+			"temp1_label":      "Package id 0",
+			"temp1_max":        "80000",
+			"temp1_crit":       "100000",
+			"temp1_crit_alarm": "0",
+		}},
+		sysfstest.Chip{Index: 3, Device: "platform/coretemp.1", Name: "coretemp", Attributes: map[string]string{
+			"temp1_input": "50000",
+		}},
+		sysfstest.Chip{Index: 10, Device: "platform/nct6775.656", Name: "nct6775", Attributes: map[string]string{
+			"uevent":           "",
+			"temp7_input":      "-128000",
+			"in0_input":        "904",
+			"in0_min":          "0",
+			"in0_max":          "1744",
+			"in0_alarm":        "1",
+			"fan2_input":       "0",
+			"fan10_input":      "1171",
+			"fan3_min":         "0",
+			"pwm1":             "51",
+			"pwm1_enable":      "2",
+			"curr1_input":      "500",
+			"power1_average":   "12500000",
+			"energy1_input":    "1000000",
+			"humidity1_input":  "45500",
+			"intrusion0_alarm": "1",
+			"in1_input":        "nan",
+		}},
+		sysfstest.Chip{Index: 2, Device: "i2c-3/3-0048", Name: "lm75", Legacy: true, Attributes: map[string]string{
+			"temp1_input": "26500",
+		}},
+	)
+
+	observed, err := lmsensors.Scan(root)
+	if err != nil {
+		t.Fatalf("Scan failed %v", err)
+	}
+
+	expected := []report.Reading{
+		{Chip: "coretemp-00", Label: "Package id 0", Channel: "temp1", Kind: report.KindTemperature, Value: 42,
+			Thresholds: map[string]float64{"max": 80, "crit": 100}, Alarms: map[string]bool{"crit_alarm": false}},
+		{Chip: "nct6775-00", Label: "in0", Channel: "in0", Kind: report.KindVoltage, Value: 0.904,
+			Thresholds: map[string]float64{"min": 0, "max": 1.744}, Alarms: map[string]bool{"alarm": true}},
+		{Chip: "nct6775-00", Label: "fan2", Channel: "fan2", Kind: report.KindFan, Value: 0},
+		{Chip: "nct6775-00", Label: "fan10", Channel: "fan10", Kind: report.KindFan, Value: 1171},
+		{Chip: "nct6775-00", Label: "pwm1", Channel: "pwm1", Kind: report.KindPWM, Value: 20},
+		{Chip: "nct6775-00", Label: "temp7", Channel: "temp7", Kind: report.KindTemperature, Value: -128},
+		{Chip: "nct6775-00", Label: "power1", Channel: "power1", Kind: report.KindPower, Value: 12.5},
+		{Chip: "nct6775-00", Label: "energy1", Channel: "energy1", Kind: report.KindEnergy, Value: 1},
+		{Chip: "nct6775-00", Label: "curr1", Channel: "curr1", Kind: report.KindCurrent, Value: 0.5},
+		{Chip: "nct6775-00", Label: "humidity1", Channel: "humidity1", Kind: report.KindHumidity, Value: 45.5},
+		{Chip: "nct6775-00", Label: "intrusion0", Channel: "intrusion0", Kind: report.KindIntrusion, Value: 1, Alarms: map[string]bool{"alarm": true}},
+		{Chip: "lm75-00", Label: "temp1", Channel: "temp1", Kind: report.KindTemperature, Value: 26.5},
+		{Chip: "coretemp-01", Label: "temp1", Channel: "temp1", Kind: report.KindTemperature, Value: 50},
+	}
+	if !reflect.DeepEqual(expected, observed) {
+		t.Errorf("Scan observed \n%+v\n, expected \n%+v\n", observed, expected)
+	}
+
+	if _, err := lmsensors.Scan(filepath.Join(root, "missing")); cache.Classify(err) != cache.KindSysfs {
+		t.Errorf("Scan of a missing sysfs observed %v, expected a sysfs error", err)
+	}
+}
+
+// TestScanLimits tests that every threshold and flag of a channel is read, and
+// that channels the chip reports as faulty are invalid.
+func TestScanLimits(t *testing.T) {
+	root := sysfstest.New(t, sysfstest.Chip{Device: "platform/nct6775.656", Name: "nct6775", Attributes: map[string]string{
+		"temp1_input":           "-273150",
+		"temp1_max":             "80000",
+		"temp1_fault":           "1",
+		"temp2_input":           "95000",
+		"temp2_min":             "5000",
+		"temp2_max":             "80000",
+		"temp2_max_hyst":        "75000",
+		"temp2_lcrit":           "0",
+		"temp2_crit":            "90000",
+		"temp2_crit_hyst":       "85000",
+		"temp2_emergency":       "105000",
+		"temp2_offset":          "0",
+		"temp2_alarm":           "1",
+		"temp2_max_alarm":       "1",
+		"temp2_crit_alarm":      "1",
+		"temp2_emergency_alarm": "0",
+		"temp2_beep":            "1",
+		"temp2_fault":           "0",
+		"power1_input":          "12500000",
+		"power1_cap":            "100000000",
+		"power1_cap_alarm":      "0",
+	}})
+
+	observed, err := lmsensors.Scan(root)
+	if err != nil {
+		t.Fatalf("Scan failed %v", err)
+	}
+
+	expected := []report.Reading{
+		{Chip: "nct6775-00", Label: "temp1", Channel: "temp1", Kind: report.KindTemperature, Invalid: true,
+			Thresholds: map[string]float64{"max": 80}},
+		{Chip: "nct6775-00", Label: "temp2", Channel: "temp2", Kind: report.KindTemperature, Value: 95,
+			Thresholds: map[string]float64{"min": 5, "max": 80, "max_hyst": 75, "lcrit": 0, "crit": 90, "crit_hyst": 85, "emergency": 105},
+			Alarms:     map[string]bool{"alarm": true, "max_alarm": true, "crit_alarm": true, "emergency_alarm": false}, Beep: true},
+		{Chip: "nct6775-00", Label: "power1", Channel: "power1", Kind: report.KindPower, Value: 12.5,
+			Thresholds: map[string]float64{"cap": 100}, Alarms: map[string]bool{"cap_alarm": false}},
+	}
+	if !reflect.DeepEqual(expected, observed) {
+		t.Errorf("Scan observed \n%+v\n, expected \n%+v\n", observed, expected)
+	}
+}
+
+// libraryTree returns a sysfs exercising every attribute the lmsensors library
+// read, with more than 10 hwmon devices, legacy chips, faulty channels and
+// channels without an input.
+func libraryTree(t *testing.T) string {
+	root := sysfstest.New(t,
+		sysfstest.Chip{Index: 0, Device: "platform/coretemp.0", Name: "coretemp", Attributes: map[string]string{
+			"temp1_input":      "42000",
+			"temp1_label":      "Package id 0",
+			"temp1_max":        "80000",
+			"temp1_crit":       "100000",
+			"temp1_crit_alarm": "0",
+			"temp2_input":      "43000",
+			"temp2_label":      "Core 0",
+		}},
+		sysfstest.Chip{Index: 1, Device: "platform/nct6775.656", Name: "nct6775", Attributes: map[string]string{
+			"uevent":           "DRIVER=nct6775",
+			"beep_enable":      "1",
+			"in0_input":        "904",
+			"in0_min":          "0",
+			"in0_max":          "1744",
+			"in0_alarm":        "1",
+			"in0_beep":         "0",
+			"in1_input":        "1800",
+			"in1_label":        "Vcore",
+			"fan1_input":       "1171",
+			"fan1_min":         "300",
+			"fan1_alarm":       "0",
+			"fan1_beep":        "1",
+			"fan1_pulses":      "2",
+			"fan1_div":         "8",
+			"fan2_input":       "0",
+			"fan10_input":      "1500",
+			"pwm1":             "51",
+			"pwm1_enable":      "2",
+			"pwm1_mode":        "1",
+			"temp1_input":      "35000",
+			"temp1_type":       "4",
+			"temp1_max":        "80000",
+			"temp1_max_hyst":   "75000",
+			"temp1_alarm":      "1",
+			"temp1_beep":       "1",
+			"temp3_fault":      "1",
+			"temp4_beep":       "0",
+			"temp5_input":      "-273150",
+			"temp5_fault":      "1",
+			"temp6_offset":     "0",
+			"curr1_input":      "500",
+			"curr1_max":        "1000",
+			"curr1_crit":       "2000",
+			"curr1_alarm":      "0",
+			"curr1_label":      "12V",
+			"energy1_input":    "1000000",
+			"humidity1_input":  "45500",
+			"intrusion0_alarm": "1",
+			"intrusion0_beep":  "0",
+			"intrusion1_alarm": "0",
+		}},
+		sysfstest.Chip{Index: 10, Device: "platform/ACPI000D:00", Name: "power_meter", Attributes: map[string]string{
+			"power1_input":            "12000000",
+			"power1_average":          "12500000",
+			"power1_average_interval": "1000",
+			"power1_is_battery":       "0",
+			"power1_model_number":     "PM-1",
+			"power1_oem_info":         "OEM",
+			"power1_serial_number":    "SN 4711",
+			"power1_cap":              "100000000",
+			"power2_average_interval": "500",
+			"power2_is_battery":       "1",
+		}},
+		sysfstest.Chip{Index: 2, Device: "i2c-3/3-0048", Name: "lm75", Legacy: true, Attributes: map[string]string{
+			"temp1_input":    "26500",
+			"temp1_max":      "80000",
+			"temp1_max_hyst": "75000",
+		}},
+		sysfstest.Chip{Index: 3, Device: "platform/coretemp.1", Name: "coretemp", Attributes: map[string]string{
+			"temp1_input": "50000",
+		}},
+		sysfstest.Chip{Index: 4, Device: "LNXSYSTM:00/LNXTHERM:00", Name: "acpitz"},
+	)
+
+	// The library walked every directory below a chip.
+	power := filepath.Join(root, "devices", "i2c-3", "3-0048", "power")
+	if err := os.MkdirAll(power, 0755); err != nil {
+		t.Fatalf("Failed to make directory %v", err)
+	}
+	sysfstest.WriteAttribute(t, power, "runtime_status", "unsupported")
+	sysfstest.WriteAttribute(t, power, "temp2_input", "27000")
+
+	return root
+}
+
+// TestUpdate tests that Update renders the sysfs at Root exactly as the
+// lmsensors library did. testdata/library.json was written by the library at
+// a2b39d81fa73, with the /sys it scanned pointed at the tree of libraryTree.
+func TestUpdate(t *testing.T) {
+	defer func(previous string) { lmsensors.Root = previous }(lmsensors.Root)
+	lmsensors.Root = libraryTree(t)
+
+	observed, err := lmsensors.Update()
+	if err != nil {
+		t.Fatalf("Update failed %v", err)
+	}
+
+	expected, err := os.ReadFile(filepath.Join("testdata", "library.json"))
+	if err != nil {
+		t.Fatalf("Failed to read testdata %v", err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(expected), observed) {
+		t.Errorf("Update observed \n%s\n, expected \n%s\n", observed, expected)
+	}
+}
+
+// TestUpdateAll tests that the data of UpdateAll is decoded to the readings of
+// Scan, that its native format is the data of Update, and that both are
+// filtered and rewritten.
+func TestUpdateAll(t *testing.T) {
+	root := sysfstest.New(t, sysfstest.Chip{Device: "platform/coretemp.0", Name: "coretemp", Attributes: map[string]string{
+		"temp1_input": "42000",
+		"temp1_label": "Package id 0",
+	}})
+	dir := sysfstest.Add(t, root, sysfstest.Chip{Index: 1, Device: "platform/nct6775.656", Name: "nct6775"})
+	sysfstest.WriteAttribute(t, dir, "fan1_input", "1171")
+
+	defer func(previous string) { lmsensors.Root = previous }(lmsensors.Root)
+	lmsensors.Root = root
+
+	data, err := lmsensors.UpdateAll()
+	if err != nil {
+		t.Fatalf("UpdateAll failed %v", err)
+	}
+	observed, err := lmsensors.Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed %v", err)
+	}
+	expected := []report.Reading{
+		{Chip: "coretemp-00", Label: "Package id 0", Channel: "temp1", Kind: report.KindTemperature, Value: 42},
+		{Chip: "nct6775-00", Label: "fan1", Channel: "fan1", Kind: report.KindFan, Value: 1171},
+	}
+	if !reflect.DeepEqual(expected, observed) {
+		t.Errorf("UpdateAll observed %+v, expected %+v", observed, expected)
+	}
+
+	devices, err := lmsensors.Update()
+	if err != nil {
+		t.Fatalf("Update failed %v", err)
+	}
+	if native, err := lmsensors.FormatDevices(data, report.Filter{}); err != nil || !bytes.Equal(devices, native) {
+		t.Errorf("FormatDevices observed %s %v, expected %s", native, err, devices)
+	}
+	native, err := lmsensors.FormatDevices(data, report.Filter{Kinds: []report.Kind{report.KindFan}})
+	if expected := `[{"Name":"nct6775-00","Sensors":[{"Name":"fan1","Alarm":false,"Beep":false,"Input":1171,"Minimum":0}]}]`; err != nil || string(native) != expected {
+		t.Errorf("FormatDevices of fans observed %s %v, expected %s", native, err, expected)
+	}
+
+	// Sensors which are dropped are removed from their device, and those
+	// which are relabelled or corrected are reencoded.
+	rewritten, err := lmsensors.Rewrite(data, func(readings []report.Reading) []report.Reading {
+		rewritten := make([]report.Reading, 0)
+		for _, r := range readings {
+			if r.Kind == report.KindTemperature {
+				r.Label, r.Value = "CPU", r.Value+1
+				rewritten = append(rewritten, r)
+			}
+		}
+		return rewritten
+	})
+	if err != nil {
+		t.Fatalf("Rewrite failed %v", err)
+	}
+	observed, err = lmsensors.Decode(rewritten)
+	if expected := []report.Reading{{Chip: "coretemp-00", Label: "CPU", Channel: "temp1", Kind: report.KindTemperature, Value: 43}}; err != nil || !reflect.DeepEqual(expected, observed) {
+		t.Errorf("Rewrite observed %+v %v, expected %+v", observed, err, expected)
+	}
+	native, err = lmsensors.FormatDevices(rewritten, report.Filter{})
+	if expected := `[{"Name":"coretemp-00","Sensors":[{"Name":"temp1","Label":"CPU","Alarm":false,"Beep":false,"Type":0,"Input":43,"High":0,"Critical":0,"CriticalAlarm":false}]},` +
+		`{"Name":"nct6775-00","Sensors":[]}]`; err != nil || string(native) != expected {
+		t.Errorf("Rewrite observed devices %s %v, expected %s", native, err, expected)
+	}
+
+	if _, err := lmsensors.Decode([]byte("not json")); err == nil {
+		t.Errorf("Decode of invalid JSON observed nil error")
+	}
+}
+
 func Benchmark(b *testing.B) {
 	c := cache.NewCache("sensor", lmsensors.Update, 60*60*24*365)
 	b.ResetTimer()
@@ -0,0 +1,174 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package platform describes the sensors of the hardware platforms gosense
+// runs on. Chips often report labels which mean nothing to whoever is on call,
+// e.g. vout1, or readings which are known to be off. Rather than relying on
+// /etc/sensors3.conf being right on every machine, each platform is described
+// in Go and applied to the readings of every monitor.
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+
+	"experimental/dwat/gosense/pkg/cache"
+	"experimental/dwat/gosense/pkg/report"
+)
+
+// ErrUnknownPlatform is returned when a platform is not in Configs.
+var ErrUnknownPlatform = errors.New("platform: unknown platform")
+
+// Sensor configures the readings of one sensor, much like the label, ignore
+// and compute statements of sensors.conf(5).
+type Sensor struct {
+	Chip     string  // Chip matches the chip as used by path.Match, e.g. ltc4151-*
+	Label    string  // Label is the label reported by the monitor, e.g. vout1
+	Name     string  // Name replaces the label, if set
+	Location string  // Location is where the sensor physically is, if set
+	Ignore   bool    // Ignore drops the readings of the sensor
+	Scale    float64 // Scale multiplies the value and thresholds, if set
+	Offset   float64 // Offset is added to the value and thresholds after Scale
+}
+
+// Config describes the sensors of a platform. Monitors name the same chip
+// differently, e.g. coretemp-isa-0000 by sensors and coretemp by hwmon, so a
+// pattern such as coretemp-* or coretemp* applies a Sensor to both.
+type Config struct {
+	Name    string
+	Sensors []Sensor
+}
+
+// Configs are the known platforms by name.
+var Configs = map[string]Config{
+	Wedge100.Name: Wedge100,
+}
+
+// Names returns the names of the known platforms in order.
+func Names() []string {
+	names := make([]string, 0, len(Configs))
+	for name := range Configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Lookup returns the config of the platform called name, or an error if it is
+// unknown or invalid.
+func Lookup(name string) (Config, error) {
+	c, ok := Configs[name]
+	if !ok {
+		return Config{}, fmt.Errorf("%w %q, expected one of %v", ErrUnknownPlatform, name, Names())
+	}
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return c, nil
+}
+
+// Validate returns an error if a chip pattern of c is malformed.
+func (c Config) Validate() error {
+	for _, s := range c.Sensors {
+		if _, err := path.Match(s.Chip, ""); err != nil {
+			return fmt.Errorf("platform: %s chip %q: %w", c.Name, s.Chip, err)
+		}
+	}
+
+	return nil
+}
+
+// sensor returns the first Sensor of c describing the reading r, or nil.
+func (c Config) sensor(r report.Reading) *Sensor {
+	for i, s := range c.Sensors {
+		if s.Label != r.Label {
+			continue
+		}
+		if matched, _ := path.Match(s.Chip, r.Chip); matched {
+			return &c.Sensors[i]
+		}
+	}
+
+	return nil
+}
+
+// Apply returns the readings named, located and corrected as described by c,
+// without the readings c ignores. The readings passed in are left unchanged.
+func (c Config) Apply(readings []report.Reading) []report.Reading {
+	applied := make([]report.Reading, 0, len(readings))
+	for _, r := range readings {
+		s := c.sensor(r)
+		if s == nil {
+			applied = append(applied, r)
+			continue
+		}
+		if s.Ignore {
+			continue
+		}
+
+		if s.Name != "" {
+			r.Label = s.Name
+		}
+		if s.Location != "" {
+			r.Location = s.Location
+		}
+		if s.Scale != 0 || s.Offset != 0 {
+			if !r.Invalid {
+				r.Value = s.correct(r.Value)
+			}
+			if r.Thresholds != nil {
+				thresholds := make(map[string]float64, len(r.Thresholds))
+				for name, value := range r.Thresholds {
+					thresholds[name] = s.correct(value)
+				}
+				r.Thresholds = thresholds
+			}
+		}
+		applied = append(applied, r)
+	}
+
+	return applied
+}
+
+// correct returns value corrected by the Scale and Offset of s.
+func (s *Sensor) correct(value float64) float64 {
+	if s.Scale != 0 {
+		value *= s.Scale
+	}
+
+	return value + s.Offset
+}
+
+// Update returns a cache.Update which applies c to the readings of update.
+// The data of update is rewritten in place by rewrite, so that the cache, and
+// therefore every format it is served in, reflects c, and whatever c does not
+// describe is left as update returned it.
+func (c Config) Update(update cache.Update, rewrite report.Rewrite) cache.Update {
+	return func() ([]byte, error) {
+		data, err := update()
+		if err != nil {
+			return data, err
+		}
+		rewritten, err := rewrite(data, c.Apply)
+		if err != nil {
+			return []byte(nil), &cache.ParseError{Err: err}
+		}
+
+		return rewritten, nil
+	}
+}
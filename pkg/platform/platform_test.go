@@ -0,0 +1,148 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"experimental/dwat/gosense/pkg/cache"
+	"experimental/dwat/gosense/pkg/platform"
+	"experimental/dwat/gosense/pkg/report"
+)
+
+func TestApply(t *testing.T) {
+	c := platform.Config{
+		Name: "test",
+		Sensors: []platform.Sensor{
+			{Chip: "ltc4151-*", Label: "vout1", Name: "Output Voltage", Location: "Hot swap controller"},
+			{Chip: "tmp75-i2c-3-48", Label: "Outlet Middle Temp", Scale: 2, Offset: -1.5},
+			{Chip: "fancpld-*", Label: "Fan 1 rear", Ignore: true},
+		},
+	}
+	readings := []report.Reading{
+		{Chip: "ltc4151-i2c-7-6f", Label: "vout1", Kind: report.KindVoltage, Value: 12.45},
+		{Chip: "ltc4151", Label: "vout1", Kind: report.KindVoltage, Value: 12.45},
+		{Chip: "tmp75-i2c-3-48", Label: "Outlet Middle Temp", Kind: report.KindTemperature, Value: 26.5,
+			Thresholds: map[string]float64{"max": 80}},
+		{Chip: "fancpld-i2c-8-33", Label: "Fan 1 front", Kind: report.KindFan, Value: 7500},
+		{Chip: "fancpld-i2c-8-33", Label: "Fan 1 rear", Kind: report.KindFan, Value: 4950},
+	}
+	expected := []report.Reading{
+		{Chip: "ltc4151-i2c-7-6f", Label: "Output Voltage", Location: "Hot swap controller", Kind: report.KindVoltage, Value: 12.45},
+		{Chip: "ltc4151", Label: "vout1", Kind: report.KindVoltage, Value: 12.45},
+		{Chip: "tmp75-i2c-3-48", Label: "Outlet Middle Temp", Kind: report.KindTemperature, Value: 51.5,
+			Thresholds: map[string]float64{"max": 158.5}},
+		{Chip: "fancpld-i2c-8-33", Label: "Fan 1 front", Kind: report.KindFan, Value: 7500},
+	}
+
+	observed := c.Apply(readings)
+	if !reflect.DeepEqual(expected, observed) {
+		t.Errorf("Apply observed \n%+v\n, expected \n%+v\n", observed, expected)
+	}
+	if readings[2].Value != 26.5 || readings[2].Thresholds["max"] != 80 {
+		t.Errorf("Apply changed the readings passed in %+v", readings[2])
+	}
+
+	update := c.Update(func() ([]byte, error) { return json.Marshal(readings) }, report.RewriteReadings)
+	data, err := update()
+	if err != nil {
+		t.Fatalf("Update failed %v", err)
+	}
+	if observed, err := decode(data); err != nil || !reflect.DeepEqual(expected, observed) {
+		t.Errorf("Update observed %+v %v, expected %+v", observed, err, expected)
+	}
+
+	update = c.Update(func() ([]byte, error) { return []byte("not json"), nil }, report.RewriteReadings)
+	if _, err := update(); cache.Classify(err) != cache.KindParse {
+		t.Errorf("Update of invalid data observed %v, expected a parse error", err)
+	}
+}
+
+// decode decodes readings encoded as a JSON array.
+func decode(data []byte) ([]report.Reading, error) {
+	var readings []report.Reading
+	err := json.Unmarshal(data, &readings)
+	return readings, err
+}
+
+// TestUpdateClassic tests that the wedge100 config renames the ltc4151 values
+// of a classic report and leaves every other value byte for byte, including
+// those which are not readings.
+func TestUpdateClassic(t *testing.T) {
+	data := []byte(`{"Information":[` +
+		`{"Adapter":"ast_i2c.3","Outlet Middle Temp":"+26.5°C","name":"tmp75-i2c-3-48"},` +
+		`{"Adapter":"ast_i2c.7","iout1":"+9.58 A","name":"ltc4151-i2c-7-6f","power1":"N/A","vout1":"+12.450 V"},` +
+		`{"Adapter":"ISA adapter","beep_enable":"enabled","fan1":"7500 RPM","in0":"+0.90 V","intrusion0":"ALARM","name":"nct6775-isa-0290"}` +
+		`],"Actions":[],"Resources":[]}`)
+	expected := []byte(`{"Information":[` +
+		`{"Adapter":"ast_i2c.3","Outlet Middle Temp":"+26.5°C","name":"tmp75-i2c-3-48"},` +
+		`{"Adapter":"ast_i2c.7","Hot Swap Output Current":"+9.58 A","Hot Swap Output Voltage":"+12.450 V","name":"ltc4151-i2c-7-6f","power1":"N/A"},` +
+		`{"Adapter":"ISA adapter","beep_enable":"enabled","fan1":"7500 RPM","in0":"+0.90 V","intrusion0":"ALARM","name":"nct6775-isa-0290"}` +
+		`],"Actions":[],"Resources":[]}`)
+
+	update := platform.Wedge100.Update(func() ([]byte, error) { return data, nil }, report.RewriteClassic)
+	observed, err := update()
+	if err != nil || !bytes.Equal(expected, observed) {
+		t.Errorf("Update observed \n%s %v\n, expected \n%s\n", observed, err, expected)
+	}
+
+	// Values c corrects are reformatted, and values it drops deleted.
+	c := platform.Config{
+		Name: "test",
+		Sensors: []platform.Sensor{
+			{Chip: "tmp75-*", Label: "Outlet Middle Temp", Offset: 1},
+			{Chip: "nct6775-*", Label: "fan1", Ignore: true},
+			{Chip: "nct6775-*", Label: "intrusion0", Ignore: true},
+		},
+	}
+	expected = []byte(`{"Information":[` +
+		`{"Adapter":"ast_i2c.3","Outlet Middle Temp":"+27.5 C","name":"tmp75-i2c-3-48"},` +
+		`{"Adapter":"ast_i2c.7","iout1":"+9.58 A","name":"ltc4151-i2c-7-6f","power1":"N/A","vout1":"+12.450 V"},` +
+		`{"Adapter":"ISA adapter","beep_enable":"enabled","in0":"+0.90 V","name":"nct6775-isa-0290"}` +
+		`],"Actions":[],"Resources":[]}`)
+	observed, err = c.Update(func() ([]byte, error) { return data, nil }, report.RewriteClassic)()
+	if err != nil || !bytes.Equal(expected, observed) {
+		t.Errorf("Update observed \n%s %v\n, expected \n%s\n", observed, err, expected)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range platform.Names() {
+		c, err := platform.Lookup(name)
+		if err != nil || c.Name != name {
+			t.Errorf("Lookup(%q) observed %s %v", name, c.Name, err)
+		}
+		if err := c.Validate(); err != nil {
+			t.Errorf("Config %s is invalid %v", name, err)
+		}
+	}
+
+	if _, err := platform.Lookup("toaster"); !errors.Is(err, platform.ErrUnknownPlatform) {
+		t.Errorf("Lookup of an unknown platform observed %v, expected %v", err, platform.ErrUnknownPlatform)
+	}
+	if err := (platform.Config{Sensors: []platform.Sensor{{Chip: "["}}}).Validate(); err == nil {
+		t.Errorf("Validate of a malformed chip observed nil error")
+	}
+
+	platform.Configs["malformed"] = platform.Config{Name: "malformed", Sensors: []platform.Sensor{{Chip: "["}}}
+	defer delete(platform.Configs, "malformed")
+	if _, err := platform.Lookup("malformed"); err == nil {
+		t.Errorf("Lookup of a malformed platform observed nil error")
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+// Wedge100 describes the sensors of a Wedge 100 whose labels are those of the
+// chip rather than anything meaningful.
+var Wedge100 = Config{
+	Name: "wedge100",
+	Sensors: []Sensor{
+		{Chip: "ltc4151-*", Label: "vout1", Name: "Hot Swap Output Voltage", Location: "Hot swap controller"},
+		{Chip: "ltc4151-*", Label: "iout1", Name: "Hot Swap Output Current", Location: "Hot swap controller"},
+	},
+}
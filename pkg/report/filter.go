@@ -0,0 +1,157 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+)
+
+// ErrBadFilter is returned when a filter can not be parsed.
+var ErrBadFilter = errors.New("report: bad filter")
+
+// ErrUnfilterable is returned when data can not be filtered.
+var ErrUnfilterable = errors.New("report: the native format of this monitor can not be filtered")
+
+// Filter selects readings by chip, label and kind. Chips and labels are
+// patterns as used by path.Match, e.g. tmp75-* or *Temp. A reading is
+// selected if it matches any of the values given for each field, so an empty
+// Filter selects every reading.
+type Filter struct {
+	Chips  []string
+	Labels []string
+	Kinds  []Kind
+}
+
+// ParseFilter returns the Filter given by the chip, label and kind parameters
+// of a query, e.g. ?chip=tmp75-*&label=*Temp&kind=fan. Each may be repeated.
+func ParseFilter(query url.Values) (Filter, error) {
+	f := Filter{Chips: query["chip"], Labels: query["label"]}
+	for _, patterns := range [][]string{f.Chips, f.Labels} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return Filter{}, fmt.Errorf("%w pattern %q", ErrBadFilter, pattern)
+			}
+		}
+	}
+	for _, kind := range query["kind"] {
+		k := Kind(kind)
+		if k.Unit() == "" && k != KindIntrusion {
+			return Filter{}, fmt.Errorf("%w kind %q", ErrBadFilter, kind)
+		}
+		f.Kinds = append(f.Kinds, k)
+	}
+
+	return f, nil
+}
+
+// Empty returns whether f selects every reading.
+func (f Filter) Empty() bool {
+	return len(f.Chips) == 0 && len(f.Labels) == 0 && len(f.Kinds) == 0
+}
+
+// Match returns whether f selects the reading r.
+func (f Filter) Match(r Reading) bool {
+	return matchAny(f.Chips, r.Chip) && matchAny(f.Labels, r.Label) && f.matchKind(r.Kind)
+}
+
+// Apply returns the readings selected by f.
+func (f Filter) Apply(readings []Reading) []Reading {
+	if f.Empty() {
+		return readings
+	}
+
+	selected := make([]Reading, 0)
+	for _, r := range readings {
+		if f.Match(r) {
+			selected = append(selected, r)
+		}
+	}
+
+	return selected
+}
+
+// ApplyClassic returns the classic report with only the values selected by f.
+// Values are kept as they are, and the kind of each is inferred as done by
+// ReadingsFromClassic, so values of no known kind are dropped if f selects
+// kinds. Chips left with no values are dropped.
+func (f Filter) ApplyClassic(classic ClassicReport) ClassicReport {
+	if f.Empty() {
+		return classic
+	}
+
+	information := make([]map[string]string, 0)
+	for _, chip := range classic.Information {
+		if !matchAny(f.Chips, chip["name"]) {
+			continue
+		}
+
+		values := make(map[string]string)
+		for label, value := range chip {
+			if label == "name" || label == "Adapter" || !matchAny(f.Labels, label) {
+				continue
+			}
+			if len(f.Kinds) > 0 {
+				if _, kind, err := ParseValue(value); err != nil || !f.matchKind(kind) {
+					continue
+				}
+			}
+			values[label] = value
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		for _, label := range []string{"name", "Adapter"} {
+			if value, ok := chip[label]; ok {
+				values[label] = value
+			}
+		}
+		information = append(information, values)
+	}
+
+	return ClassicReport{Information: information, Actions: classic.Actions, Resources: classic.Resources}
+}
+
+// matchKind returns whether f selects the kind.
+func (f Filter) matchKind(kind Kind) bool {
+	if len(f.Kinds) == 0 {
+		return true
+	}
+	for _, k := range f.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchAny returns whether name matches any of patterns, or true if there are
+// none. The patterns have already been checked by ParseFilter.
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,91 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report_test
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"experimental/dwat/gosense/pkg/report"
+)
+
+var filterReadings = []report.Reading{
+	{Chip: "tmp75-i2c-3-48", Label: "Outlet Middle Temp", Kind: report.KindTemperature, Value: 26.5},
+	{Chip: "tmp75-i2c-3-49", Label: "Inlet Middle Temp", Kind: report.KindTemperature, Value: 22},
+	{Chip: "fancpld-i2c-8-33", Label: "Fan 1 front", Kind: report.KindFan, Value: 7500},
+	{Chip: "ltc4151-i2c-7-6f", Label: "vout1", Kind: report.KindVoltage, Value: 12.37},
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []report.Reading
+	}{
+		{"", filterReadings},
+		{"chip=tmp75-*", filterReadings[:2]},
+		{"label=*Temp&label=vout?", []report.Reading{filterReadings[0], filterReadings[1], filterReadings[3]}},
+		{"kind=fan", filterReadings[2:3]},
+		{"chip=tmp75-*&label=Inlet*", filterReadings[1:2]},
+		{"chip=tmp75-*&kind=fan", []report.Reading{}},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		f, err := report.ParseFilter(query)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q) failed %v", tt.query, err)
+		}
+		if observed := f.Apply(filterReadings); !reflect.DeepEqual(observed, tt.expected) {
+			t.Errorf("Filter %q observed %+v, expected %+v", tt.query, observed, tt.expected)
+		}
+	}
+
+	for _, invalid := range []string{"chip=[", "label=a[b", "kind=fans", "kind=unknown"} {
+		query, _ := url.ParseQuery(invalid)
+		if _, err := report.ParseFilter(query); !errors.Is(err, report.ErrBadFilter) {
+			t.Errorf("ParseFilter(%q) observed %v, expected %v", invalid, err, report.ErrBadFilter)
+		}
+	}
+}
+
+func TestFilterClassic(t *testing.T) {
+	classic := report.ClassicReport{
+		Information: []map[string]string{
+			{"name": "tmp75-i2c-3-48", "Adapter": "ast_i2c.3", "Outlet Middle Temp": "+26.5 C"},
+			{"name": "fancpld-i2c-8-33", "Adapter": "ast_i2c.8", "Fan 1 front": "7500 RPM", "Fan 1 rear": "N/A"},
+		},
+		Actions:   []map[string]string{},
+		Resources: []map[string]string{},
+	}
+
+	f := report.Filter{Labels: []string{"Fan 1 *"}}
+	expected := report.ClassicReport{
+		Information: []map[string]string{classic.Information[1]},
+		Actions:     []map[string]string{},
+		Resources:   []map[string]string{},
+	}
+	if observed := f.ApplyClassic(classic); !reflect.DeepEqual(observed, expected) {
+		t.Errorf("Filter of labels observed %v, expected %v", observed, expected)
+	}
+
+	// Values of no known kind are dropped when filtering by kind.
+	f = report.Filter{Kinds: []report.Kind{report.KindFan}}
+	expected.Information = []map[string]string{{"name": "fancpld-i2c-8-33", "Adapter": "ast_i2c.8", "Fan 1 front": "7500 RPM"}}
+	if observed := f.ApplyClassic(classic); !reflect.DeepEqual(observed, expected) {
+		t.Errorf("Filter of kinds observed %v, expected %v", observed, expected)
+	}
+}
@@ -16,6 +16,7 @@ package report
 
 import (
 	"encoding/json"
+	"sort"
 )
 
 // FormatClassicInformation returns a JSON ClassicReport with information.
@@ -31,3 +32,45 @@ func FormatClassicInformation(information []map[string]string) []byte {
 
 	return encoded
 }
+
+// RewriteClassic is a Rewrite for the monitors whose cache holds a
+// ClassicReport. fn is given each value of the information on its own, as a
+// reading labelled with its key. Values which can not be parsed, such as
+// "N/A" or "ALARM", are given as invalid readings. A value fn drops is
+// deleted and one fn relabels is moved to the new label, but it is only
+// reformatted if fn changes it, so values fn leaves alone are kept byte for
+// byte.
+func RewriteClassic(data []byte, fn func([]Reading) []Reading) ([]byte, error) {
+	var classic ClassicReport
+	if err := json.Unmarshal(data, &classic); err != nil {
+		return nil, err
+	}
+
+	for i, chip := range classic.Information {
+		labels := make([]string, 0, len(chip))
+		rewritten := make(map[string]string, len(chip))
+		for label, value := range chip {
+			if label == "name" || label == "Adapter" {
+				rewritten[label] = value
+				continue
+			}
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		for _, label := range labels {
+			value, kind, err := ParseValue(chip[label])
+			r := Reading{Chip: chip["name"], Adapter: chip["Adapter"], Label: label, Kind: kind, Value: value, Invalid: err != nil}
+			for _, w := range fn([]Reading{r}) {
+				formatted := chip[label]
+				if !r.Invalid && (w.Value != r.Value || w.Kind != r.Kind) {
+					formatted = FormatValue(w.Kind, w.Value)
+				}
+				rewritten[w.Label] = formatted
+			}
+		}
+		classic.Information[i] = rewritten
+	}
+
+	return json.Marshal(classic)
+}
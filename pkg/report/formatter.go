@@ -0,0 +1,272 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknownFormat is returned when a requested format is not supported.
+var ErrUnknownFormat = errors.New("report: unknown format")
+
+// Document is everything a Formatter may render for one monitor.
+type Document struct {
+	Monitor  string    // Monitor is the name of the monitor
+	Time     time.Time // Time is when the data was collected
+	Data     []byte    // Data is the representation held by the monitor's cache
+	Classic  bool      // Classic is set if Data is already a ClassicReport
+	Readings []Reading // Readings are decoded from Data
+}
+
+// Formatter renders a Document in one representation.
+type Formatter interface {
+	Name() string                         // Name selects the formatter with ?format=
+	ContentType() string                  // ContentType selects the formatter with Accept
+	Format(w io.Writer, d Document) error // Format writes the representation of d
+}
+
+// formatter is a Formatter made from a function.
+type formatter struct {
+	name        string
+	contentType string
+	format      func(w io.Writer, d Document) error
+}
+
+func (f *formatter) Name() string                         { return f.name }
+func (f *formatter) ContentType() string                  { return f.contentType }
+func (f *formatter) Format(w io.Writer, d Document) error { return f.format(w, d) }
+
+var (
+	// Native writes the data held by the cache unchanged.
+	Native Formatter = &formatter{"native", "application/json", formatNative}
+	// Classic writes a ClassicReport.
+	Classic Formatter = &formatter{"classic", "application/json", formatClassic}
+	// Normalized writes a SensorsReport.
+	Normalized Formatter = &formatter{"normalized", SensorsContentType, formatNormalized}
+	// Prometheus writes the readings in the Prometheus text format.
+	Prometheus Formatter = &formatter{"prometheus", PrometheusContentType, formatPrometheus}
+	// OpenMetrics writes the readings in the OpenMetrics text format.
+	OpenMetrics Formatter = &formatter{"openmetrics", OpenMetricsContentType, formatOpenMetrics}
+	// CSV writes one line per reading with a header.
+	CSV Formatter = &formatter{"csv", "text/csv; charset=utf-8", formatCSV}
+	// Text writes the readings in the style of the sensors command.
+	Text Formatter = &formatter{"text", "text/plain; charset=utf-8", formatText}
+)
+
+// Formatters are the supported formatters in order of preference when an
+// Accept header matches more than one.
+var Formatters = []Formatter{Native, Classic, Normalized, CSV, Text, Prometheus, OpenMetrics}
+
+// Negotiate selects a formatter by name if format is set, or else from an
+// Accept header. The preferred formatter is selected if neither asks for
+// anything supported, and over any other formatter with the same content
+// type.
+func Negotiate(format, accept string, preferred Formatter) (Formatter, error) {
+	if format != "" {
+		for _, f := range Formatters {
+			if f.Name() == format {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+
+	candidates := append([]Formatter{preferred}, Formatters...)
+	for _, r := range parseAccept(accept) {
+		if r.mediaType == "*/*" {
+			return preferred, nil
+		}
+		for _, f := range candidates {
+			if r.matches(f.ContentType()) {
+				return f, nil
+			}
+		}
+	}
+
+	return preferred, nil
+}
+
+// mediaRange is one media range of an Accept header.
+type mediaRange struct {
+	mediaType string
+	params    map[string]string
+	q         float64
+}
+
+// parseAccept returns the acceptable media ranges of an Accept header from the
+// most to the least preferred.
+func parseAccept(accept string) []mediaRange {
+	ranges := make([]mediaRange, 0)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		r := mediaRange{mediaType: mediaType, params: params, q: 1}
+		if q, ok := params["q"]; ok {
+			if r.q, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if r.q > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	return ranges
+}
+
+// matches returns whether contentType is in the media range. If the range has
+// a version it must match, which tells the Prometheus text format apart from
+// plain text.
+func (r mediaRange) matches(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if version, ok := r.params["version"]; ok && version != params["version"] {
+		return false
+	}
+	if strings.HasSuffix(r.mediaType, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(r.mediaType, "*"))
+	}
+
+	return r.mediaType == mediaType
+}
+
+// formatNative writes the data unchanged.
+func formatNative(w io.Writer, d Document) error {
+	_, err := w.Write(d.Data)
+	return err
+}
+
+// formatClassic writes the data if it is already classic, or else a
+// ClassicReport made from the readings.
+func formatClassic(w io.Writer, d Document) error {
+	if d.Classic {
+		return formatNative(w, d)
+	}
+
+	_, err := w.Write(FormatClassicInformation(ClassicInformation(d.Readings)))
+	return err
+}
+
+// formatNormalized writes a SensorsReport.
+func formatNormalized(w io.Writer, d Document) error {
+	return json.NewEncoder(w).Encode(NewSensorsReport(d))
+}
+
+// formatPrometheus writes the readings in the Prometheus text format.
+func formatPrometheus(w io.Writer, d Document) error {
+	m := NewMetrics()
+	m.AddReadings(d.Monitor, d.Time, d.Readings)
+	return m.WritePrometheus(w)
+}
+
+// formatOpenMetrics writes the readings in the OpenMetrics text format.
+func formatOpenMetrics(w io.Writer, d Document) error {
+	m := NewMetrics()
+	m.AddReadings(d.Monitor, d.Time, d.Readings)
+	return m.WriteOpenMetrics(w)
+}
+
+// formatCSV writes one line per reading after a header. Invalid readings have
+// no value.
+func formatCSV(w io.Writer, d Document) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"monitor", "chip", "label", "kind", "value", "unit"})
+	for _, r := range d.Readings {
+		value := strconv.FormatFloat(r.Value, 'f', -1, 64)
+		if r.Invalid {
+			value = ""
+		}
+		cw.Write([]string{d.Monitor, r.Chip, r.Label, string(r.Kind), value, r.Kind.Unit()})
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// formatText writes each chip followed by its readings and their thresholds,
+// in the style of the sensors command.
+func formatText(w io.Writer, d Document) error {
+	var b bytes.Buffer
+	for i, chip := range groupByChip(d.Readings) {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(chip[0].Chip + "\n")
+		for _, r := range chip {
+			b.WriteString(r.Label + ":  " + formatReading(r))
+			if len(r.Thresholds) > 0 {
+				thresholds := make([]string, 0, len(r.Thresholds))
+				for _, name := range sortedKeys(r.Thresholds) {
+					thresholds = append(thresholds, name+" = "+FormatValue(r.Kind, r.Thresholds[name]))
+				}
+				b.WriteString("  (" + strings.Join(thresholds, ", ") + ")")
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// ClassicInformation converts readings to the information of a
+// ClassicReport, with one map per chip. Invalid readings are FAULT.
+func ClassicInformation(readings []Reading) []map[string]string {
+	information := make([]map[string]string, 0)
+	for _, chip := range groupByChip(readings) {
+		values := map[string]string{"name": chip[0].Chip}
+		if chip[0].Adapter != "" {
+			values["Adapter"] = chip[0].Adapter
+		}
+		for _, r := range chip {
+			values[r.Label] = formatReading(r)
+		}
+		information = append(information, values)
+	}
+
+	return information
+}
+
+// groupByChip groups readings by chip in the order each chip first appears.
+func groupByChip(readings []Reading) [][]Reading {
+	chips := make([][]Reading, 0)
+	index := make(map[string]int)
+	for _, r := range readings {
+		i, ok := index[r.Chip]
+		if !ok {
+			i = len(chips)
+			index[r.Chip] = i
+			chips = append(chips, nil)
+		}
+		chips[i] = append(chips[i], r)
+	}
+
+	return chips
+}
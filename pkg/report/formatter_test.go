@@ -0,0 +1,147 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"experimental/dwat/gosense/pkg/report"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		format   string
+		accept   string
+		expected report.Formatter
+	}{
+		{"", "", report.Native},
+		{"", "*/*", report.Native},
+		{"", "application/json", report.Native},
+		{"classic", "text/csv", report.Classic},
+		{"", "text/csv", report.CSV},
+		{"", "text/plain", report.Text},
+		{"", "text/plain;version=0.0.4;q=0.5,*/*;q=0.1", report.Prometheus},
+		{"", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5", report.OpenMetrics},
+		{"", "text/html;q=0.9,application/vnd.gosense.sensors.v2+json", report.Normalized},
+		{"", "image/png", report.Native},
+	}
+
+	for _, tt := range tests {
+		observed, err := report.Negotiate(tt.format, tt.accept, report.Native)
+		if err != nil || observed != tt.expected {
+			t.Errorf("Negotiate(%q, %q) observed %v %v, expected %s", tt.format, tt.accept, observed, err, tt.expected.Name())
+		}
+	}
+
+	// A monitor may prefer another formatter with the same content type.
+	for _, accept := range []string{"", "*/*", "application/json"} {
+		if observed, err := report.Negotiate("", accept, report.Classic); err != nil || observed != report.Classic {
+			t.Errorf("Negotiate(%q) preferring classic observed %v %v", accept, observed, err)
+		}
+	}
+	if observed, _ := report.Negotiate("native", "", report.Classic); observed != report.Native {
+		t.Errorf("Negotiate of native preferring classic observed %v", observed)
+	}
+
+	if _, err := report.Negotiate("xml", "", report.Native); !errors.Is(err, report.ErrUnknownFormat) {
+		t.Errorf("Negotiate of unknown format observed %v, expected %v", err, report.ErrUnknownFormat)
+	}
+}
+
+func TestFormatters(t *testing.T) {
+	d := report.Document{
+		Monitor: "sensors",
+		Time:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Data:    []byte(`[{"Name":"coretemp-isa-0000"}]`),
+		Readings: []report.Reading{
+			{Chip: "coretemp-isa-0000", Label: "Core 0", Kind: report.KindTemperature, Value: 42, Thresholds: map[string]float64{"crit": 100, "max": 80}},
+			{Chip: "coretemp-isa-0000", Label: "Core 1", Kind: report.KindTemperature, Value: 41.5},
+			{Chip: "nct6775-isa-0290", Adapter: "ISA adapter", Label: "fan1", Kind: report.KindFan, Value: 1200, Alarms: map[string]bool{"alarm": true}},
+			{Chip: "nct6775-isa-0290", Adapter: "ISA adapter", Label: "temp3", Kind: report.KindTemperature, Invalid: true, Thresholds: map[string]float64{"max": 80}},
+		},
+	}
+
+	tests := []struct {
+		formatter report.Formatter
+		expected  string
+	}{
+		{report.Native, `[{"Name":"coretemp-isa-0000"}]`},
+		{report.Classic, `{"Information":[{"Core 0":"+42.0 C","Core 1":"+41.5 C","name":"coretemp-isa-0000"},{"Adapter":"ISA adapter","fan1":"1200 RPM","name":"nct6775-isa-0290","temp3":"FAULT"}],"Actions":[],"Resources":[]}`},
+		{report.Normalized, `{"version":2,"monitor":"sensors","time":"2020-01-02T03:04:05Z","readings":[{"chip":"coretemp-isa-0000","label":"Core 0","kind":"temperature","value":42,"unit":"celsius","thresholds":{"crit":100,"max":80}},{"chip":"coretemp-isa-0000","label":"Core 1","kind":"temperature","value":41.5,"unit":"celsius"},{"chip":"nct6775-isa-0290","adapter":"ISA adapter","label":"fan1","kind":"fan","value":1200,"unit":"rpm","alarms":{"alarm":true}},{"chip":"nct6775-isa-0290","adapter":"ISA adapter","label":"temp3","kind":"temperature","value":0,"invalid":true,"unit":"celsius","thresholds":{"max":80}}]}
+`},
+		{report.CSV, `monitor,chip,label,kind,value,unit
+sensors,coretemp-isa-0000,Core 0,temperature,42,celsius
+sensors,coretemp-isa-0000,Core 1,temperature,41.5,celsius
+sensors,nct6775-isa-0290,fan1,fan,1200,rpm
+sensors,nct6775-isa-0290,temp3,temperature,,celsius
+`},
+		{report.Text, `coretemp-isa-0000
+Core 0:  +42.0 C  (crit = +100.0 C, max = +80.0 C)
+Core 1:  +41.5 C
+
+nct6775-isa-0290
+fan1:  1200 RPM
+temp3:  FAULT  (max = +80.0 C)
+`},
+	}
+
+	for _, tt := range tests {
+		var observed bytes.Buffer
+		if err := tt.formatter.Format(&observed, d); err != nil {
+			t.Fatalf("Format %s failed %v", tt.formatter.Name(), err)
+		}
+		if observed.String() != tt.expected {
+			t.Errorf("Format %s observed \n%s\n, expected \n%s\n", tt.formatter.Name(), observed.String(), tt.expected)
+		}
+	}
+
+	// Data which is already classic is served unchanged.
+	d.Classic = true
+	var observed bytes.Buffer
+	if err := report.Classic.Format(&observed, d); err != nil || observed.String() != string(d.Data) {
+		t.Errorf("Format classic observed %s %v, expected %s", observed.String(), err, d.Data)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		kind     report.Kind
+		value    float64
+		expected string
+	}{
+		{report.KindTemperature, 26.5, "+26.5 C"},
+		{report.KindTemperature, -3, "-3.0 C"},
+		{report.KindVoltage, 0.5, "+0.50 V"},
+		{report.KindFan, 7500, "7500 RPM"},
+		{report.KindPower, 35.25, "35.25 W"},
+		{report.KindIntrusion, 1, "ALARM"},
+	}
+
+	for _, tt := range tests {
+		observed := report.FormatValue(tt.kind, tt.value)
+		if observed != tt.expected {
+			t.Errorf("FormatValue(%s, %v) observed %q, expected %q", tt.kind, tt.value, observed, tt.expected)
+		}
+		if tt.kind == report.KindIntrusion {
+			continue
+		}
+		if value, kind, err := report.ParseValue(observed); err != nil || value != tt.value || kind != tt.kind {
+			t.Errorf("ParseValue(%q) observed %v %v %v, expected %v %v", observed, value, kind, err, tt.value, tt.kind)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// OpenMetrics is the Prometheus text format with units, timestamps and an
+// explicit end of exposition:
+// https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md
+
+// OpenMetricsContentType is the content type of the OpenMetrics text format.
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// AcceptsOpenMetrics returns whether an Accept header prefers OpenMetrics to
+// the Prometheus text format, e.g. the header sent by Prometheus:
+// application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1
+// The text format is weighted by the most specific of text/plain, text/* and
+// */* in the header. Ties go to OpenMetrics, which the client named.
+func AcceptsOpenMetrics(accept string) bool {
+	openMetrics := 0.0
+	text := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		weight := 1.0
+		if q, ok := params["q"]; ok {
+			if weight, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "application/openmetrics-text":
+			if weight > openMetrics {
+				openMetrics = weight
+			}
+		case "text/plain", "text/*", "*/*":
+			if previous, ok := text[mediaType]; !ok || weight > previous {
+				text[mediaType] = weight
+			}
+		}
+	}
+
+	for _, mediaType := range []string{"text/plain", "text/*", "*/*"} {
+		if weight, ok := text[mediaType]; ok {
+			return openMetrics > 0 && openMetrics >= weight
+		}
+	}
+
+	return openMetrics > 0
+}
+
+// WriteOpenMetrics renders the metrics in the OpenMetrics text format. Each
+// family declares its unit, if any, and samples with a timestamp are written
+// with it in seconds. The family name of a counter omits the _total suffix of
+// its samples.
+func (m *Metrics) WriteOpenMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range m.families {
+		name := f.Name
+		if f.Type == Counter {
+			name = strings.TrimSuffix(name, "_total")
+		}
+
+		bw.WriteString("# TYPE " + name + " " + string(f.Type) + "\n")
+		if f.Unit != "" {
+			bw.WriteString("# UNIT " + name + " " + f.Unit + "\n")
+		}
+		bw.WriteString("# HELP " + name + " " + escapeLabel(f.Help) + "\n")
+		for _, s := range f.Samples {
+			writeSample(bw, name+counterSuffix(f.Type), s)
+			if !s.Timestamp.IsZero() {
+				bw.WriteString(" " + strconv.FormatFloat(float64(s.Timestamp.UnixMilli())/1e3, 'f', -1, 64))
+			}
+			bw.WriteString("\n")
+		}
+	}
+	bw.WriteString("# EOF\n")
+
+	return bw.Flush()
+}
+
+// counterSuffix returns the suffix of samples of a family of type typ.
+func counterSuffix(typ MetricType) string {
+	if typ == Counter {
+		return "_total"
+	}
+
+	return ""
+}
@@ -0,0 +1,80 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"experimental/dwat/gosense/pkg/report"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	m := report.NewMetrics()
+	m.Add("gosense_monitor_updates_total", report.Counter, "Number of \"updates\".", 3, report.Label{Name: "monitor", Value: "csensors"})
+	m.AddReadings("csensors", time.Unix(1520879607, 789e6), []report.Reading{
+		{Chip: "tmp75-i2c-3-48", Label: "Outlet Middle Temp", Kind: report.KindTemperature, Value: 26.5, Thresholds: map[string]float64{"high": 80}},
+		{Chip: "it8728-isa-0a30", Label: "intrusion0", Kind: report.KindIntrusion, Value: 0},
+	})
+
+	expected := `# TYPE gosense_monitor_updates counter
+# HELP gosense_monitor_updates Number of \"updates\".
+gosense_monitor_updates_total{monitor="csensors"} 3
+# TYPE gosense_temperature_celsius gauge
+# UNIT gosense_temperature_celsius celsius
+# HELP gosense_temperature_celsius Sensor readings of kind temperature.
+gosense_temperature_celsius{monitor="csensors",chip="tmp75-i2c-3-48",label="Outlet Middle Temp"} 26.5 1520879607.789
+# TYPE gosense_temperature_threshold_celsius gauge
+# UNIT gosense_temperature_threshold_celsius celsius
+# HELP gosense_temperature_threshold_celsius Sensor thresholds of kind temperature.
+gosense_temperature_threshold_celsius{monitor="csensors",chip="tmp75-i2c-3-48",label="Outlet Middle Temp",threshold="high"} 80 1520879607.789
+# TYPE gosense_intrusion gauge
+# HELP gosense_intrusion Sensor readings of kind intrusion.
+gosense_intrusion{monitor="csensors",chip="it8728-isa-0a30",label="intrusion0"} 0 1520879607.789
+# EOF
+`
+
+	var observed bytes.Buffer
+	if err := m.WriteOpenMetrics(&observed); err != nil {
+		t.Fatalf("WriteOpenMetrics failed %v", err)
+	}
+	if observed.String() != expected {
+		t.Errorf("OpenMetrics format observed \n%s\n, expected \n%s\n", observed.String(), expected)
+	}
+}
+
+func TestAcceptsOpenMetrics(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{"application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", true},
+		{"text/plain;version=0.0.4;q=1,*/*;q=0.1", false},
+		{"application/openmetrics-text; q=0", false},
+		{"text/plain;q=1, application/openmetrics-text;q=0.1", false},
+		{"application/openmetrics-text;q=0.5, text/*;q=0.4, */*;q=1", true},
+		{"application/openmetrics-text, text/plain", true},
+		{"application/openmetrics-text", true},
+		{"application/openmetrics-text;q=0.1, text/plain;q=0, */*", true},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if observed := report.AcceptsOpenMetrics(tt.accept); observed != tt.expected {
+			t.Errorf("AcceptsOpenMetrics(%q) observed %v, expected %v", tt.accept, observed, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// We render the Prometheus text format ourselves rather than depending on
+// client_golang, which would roughly double the size of the binary:
+// https://github.com/prometheus/docs/blob/master/content/docs/instrumenting/exposition_formats.md
+
+// PrometheusContentType is the content type of the Prometheus text format.
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// MetricType is the type of a metric family.
+type MetricType string
+
+const (
+	Gauge   MetricType = "gauge"   // Gauges may go up and down
+	Counter MetricType = "counter" // Counters only go up
+)
+
+// Label is a metric label.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one value of a metric family.
+type Sample struct {
+	Labels    []Label
+	Value     float64
+	Timestamp time.Time // Timestamp is when the value was observed, or zero for now
+}
+
+// Family is a group of samples sharing a name, type, unit and help text.
+type Family struct {
+	Name    string
+	Type    MetricType
+	Unit    string // Unit is the suffix of Name naming its unit, if any
+	Help    string
+	Samples []Sample
+}
+
+// metricUnits are the metric name suffixes which are declared as units.
+var metricUnits = []string{"celsius", "volts", "amperes", "watts", "joules", "rpm", "percent", "seconds", "bytes"}
+
+// Metrics collects metric families in the order they are first added, since
+// all samples of a family must be rendered together.
+type Metrics struct {
+	families []*Family
+	index    map[string]*Family
+}
+
+// NewMetrics returns an empty collection of metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{index: make(map[string]*Family)}
+}
+
+// Add adds a sample to the family name, creating the family if necessary.
+func (m *Metrics) Add(name string, typ MetricType, help string, value float64, labels ...Label) {
+	m.AddSample(name, typ, help, Sample{Labels: labels, Value: value})
+}
+
+// AddSample adds a sample to the family name, creating the family if
+// necessary.
+func (m *Metrics) AddSample(name string, typ MetricType, help string, sample Sample) {
+	family, ok := m.index[name]
+	if !ok {
+		family = &Family{Name: name, Type: typ, Unit: unitOf(name, typ), Help: help}
+		m.families = append(m.families, family)
+		m.index[name] = family
+	}
+
+	family.Samples = append(family.Samples, sample)
+}
+
+// unitOf returns the unit named by the suffix of a metric name, ignoring the
+// _total suffix of counters.
+func unitOf(name string, typ MetricType) string {
+	if typ == Counter {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	for _, unit := range metricUnits {
+		if strings.HasSuffix(name, "_"+unit) {
+			return unit
+		}
+	}
+
+	return ""
+}
+
+// Families returns the families collected so far.
+func (m *Metrics) Families() []*Family {
+	return m.families
+}
+
+// AddReadings adds a gauge for each reading, and for each of its thresholds,
+// e.g. gosense_temperature_celsius{monitor="csensors",chip="tmp75-i2c-3-48",label="Outlet Middle Temp"}.
+// Readings of unknown kind are skipped, as are the values of invalid readings.
+// Each sample is stamped with the time the readings were taken.
+func (m *Metrics) AddReadings(monitor string, timestamp time.Time, readings []Reading) {
+	for _, r := range readings {
+		if r.Kind == KindUnknown {
+			continue
+		}
+
+		labels := []Label{{"monitor", monitor}, {"chip", r.Chip}, {"label", r.Label}}
+		if r.Location != "" {
+			labels = append(labels, Label{"location", r.Location})
+		}
+		// Each threshold appends to labels, which must not share the array.
+		if !r.Invalid {
+			m.AddSample(ReadingMetric(r.Kind), Gauge, "Sensor readings of kind "+string(r.Kind)+".",
+				Sample{Labels: labels, Value: r.Value, Timestamp: timestamp})
+		}
+
+		for _, name := range sortedKeys(r.Thresholds) {
+			m.AddSample(ThresholdMetric(r.Kind), Gauge, "Sensor thresholds of kind "+string(r.Kind)+".",
+				Sample{Labels: append(labels[:len(labels):len(labels)], Label{"threshold", name}), Value: r.Thresholds[name], Timestamp: timestamp})
+		}
+	}
+}
+
+// ReadingMetric returns the name of the metric for readings of kind k.
+func ReadingMetric(k Kind) string {
+	return metricName("gosense", string(k), k.Unit())
+}
+
+// ThresholdMetric returns the name of the metric for thresholds of kind k.
+func ThresholdMetric(k Kind) string {
+	return metricName("gosense", string(k), "threshold", k.Unit())
+}
+
+// metricName joins the non-empty parts of a metric name.
+func metricName(parts ...string) string {
+	nonEmpty := parts[:0:0]
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+
+	return strings.Join(nonEmpty, "_")
+}
+
+// WritePrometheus renders the metrics in the Prometheus text format. Units and
+// timestamps are left out, since Prometheus assumes a sample without a
+// timestamp was observed when it was scraped.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range m.families {
+		bw.WriteString("# HELP " + f.Name + " " + escapeHelp(f.Help) + "\n")
+		bw.WriteString("# TYPE " + f.Name + " " + string(f.Type) + "\n")
+		for _, s := range f.Samples {
+			writeSample(bw, f.Name, s)
+			bw.WriteString("\n")
+		}
+	}
+
+	return bw.Flush()
+}
+
+// writeSample writes a sample without a trailing newline.
+func writeSample(bw *bufio.Writer, name string, s Sample) {
+	bw.WriteString(name)
+	if len(s.Labels) > 0 {
+		bw.WriteByte('{')
+		for i, l := range s.Labels {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			bw.WriteString(l.Name + `="` + escapeLabel(l.Value) + `"`)
+		}
+		bw.WriteByte('}')
+	}
+	bw.WriteByte(' ')
+	bw.WriteString(formatFloat(s.Value))
+}
+
+// formatFloat formats a sample value.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// escapeHelp escapes help text.
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+// escapeLabel escapes a label value.
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
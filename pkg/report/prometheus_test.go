@@ -0,0 +1,94 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"experimental/dwat/gosense/pkg/report"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	m := report.NewMetrics()
+	m.Add("gosense_monitor_up", report.Gauge, "Whether the last update succeeded.", 1, report.Label{Name: "monitor", Value: "csensors"})
+	m.AddReadings("csensors", time.Time{}, []report.Reading{
+		{Chip: "tmp75-i2c-3-48", Label: "Outlet Middle Temp", Kind: report.KindTemperature, Value: 26.5, Thresholds: map[string]float64{"high": 80, "hyst": 75}},
+		{Chip: "fancpld-i2c-8-33", Label: "Fan 1 \"rear\"\n", Location: "Fan tray 1", Kind: report.KindFan, Value: 4950, Thresholds: map[string]float64{"min": 300, "max": 9000}},
+		{Chip: "acpitz-virtual-0", Label: "temp1", Kind: report.KindTemperature, Value: math.Inf(1)},
+		{Chip: "mystery", Label: "thing", Kind: report.KindUnknown, Value: 1},
+	})
+	m.Add("gosense_self_cpu_seconds_total", report.Counter, "CPU time used.\\", math.NaN())
+
+	expected := `# HELP gosense_monitor_up Whether the last update succeeded.
+# TYPE gosense_monitor_up gauge
+gosense_monitor_up{monitor="csensors"} 1
+# HELP gosense_temperature_celsius Sensor readings of kind temperature.
+# TYPE gosense_temperature_celsius gauge
+gosense_temperature_celsius{monitor="csensors",chip="tmp75-i2c-3-48",label="Outlet Middle Temp"} 26.5
+gosense_temperature_celsius{monitor="csensors",chip="acpitz-virtual-0",label="temp1"} +Inf
+# HELP gosense_temperature_threshold_celsius Sensor thresholds of kind temperature.
+# TYPE gosense_temperature_threshold_celsius gauge
+gosense_temperature_threshold_celsius{monitor="csensors",chip="tmp75-i2c-3-48",label="Outlet Middle Temp",threshold="high"} 80
+gosense_temperature_threshold_celsius{monitor="csensors",chip="tmp75-i2c-3-48",label="Outlet Middle Temp",threshold="hyst"} 75
+# HELP gosense_fan_rpm Sensor readings of kind fan.
+# TYPE gosense_fan_rpm gauge
+gosense_fan_rpm{monitor="csensors",chip="fancpld-i2c-8-33",label="Fan 1 \"rear\"\n",location="Fan tray 1"} 4950
+# HELP gosense_fan_threshold_rpm Sensor thresholds of kind fan.
+# TYPE gosense_fan_threshold_rpm gauge
+gosense_fan_threshold_rpm{monitor="csensors",chip="fancpld-i2c-8-33",label="Fan 1 \"rear\"\n",location="Fan tray 1",threshold="max"} 9000
+gosense_fan_threshold_rpm{monitor="csensors",chip="fancpld-i2c-8-33",label="Fan 1 \"rear\"\n",location="Fan tray 1",threshold="min"} 300
+# HELP gosense_self_cpu_seconds_total CPU time used.\\
+# TYPE gosense_self_cpu_seconds_total counter
+gosense_self_cpu_seconds_total NaN
+`
+
+	var observed bytes.Buffer
+	if err := m.WritePrometheus(&observed); err != nil {
+		t.Fatalf("WritePrometheus failed %v", err)
+	}
+	if observed.String() != expected {
+		t.Errorf("Prometheus format observed \n%s\n, expected \n%s\n", observed.String(), expected)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected float64
+		kind     report.Kind
+		fails    bool
+	}{
+		{"+26.5 C", 26.5, report.KindTemperature, false},
+		{"+27.8°C", 27.8, report.KindTemperature, false},
+		{"NaN C", 0, report.KindUnknown, true},
+		{"1e308 kW", 0, report.KindUnknown, true},
+		{"+12.37 V", 12.37, report.KindVoltage, false},
+		{"500 mA", 0.5, report.KindCurrent, false},
+		{"7500 RPM", 7500, report.KindFan, false},
+		{"40.00 %", 40, report.KindPWM, false},
+		{"N/A", 0, report.KindUnknown, true},
+		{"+1.0 furlongs", 0, report.KindUnknown, true},
+	}
+
+	for _, tt := range tests {
+		value, kind, err := report.ParseValue(tt.value)
+		if (err != nil) != tt.fails || value != tt.expected || kind != tt.kind {
+			t.Errorf("ParseValue(%q) observed %v %v %v, expected %v %v", tt.value, value, kind, err, tt.expected, tt.kind)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind is the physical quantity measured by a sensor.
+type Kind string
+
+const (
+	KindTemperature Kind = "temperature" // Degrees Celsius
+	KindVoltage     Kind = "voltage"     // Volts
+	KindCurrent     Kind = "current"     // Amperes
+	KindPower       Kind = "power"       // Watts
+	KindEnergy      Kind = "energy"      // Joules
+	KindFan         Kind = "fan"         // Revolutions per minute
+	KindHumidity    Kind = "humidity"    // Percent relative humidity
+	KindPWM         Kind = "pwm"         // Percent duty cycle of a fan
+	KindIntrusion   Kind = "intrusion"   // Chassis intrusion, 1 if detected
+	KindUnknown     Kind = "unknown"     // Anything else
+)
+
+// units maps the units printed by the sensors command to the kind of sensor
+// and the multiplier to convert to the base unit of that kind.
+var units = map[string]struct {
+	kind       Kind
+	multiplier float64
+}{
+	"C":   {KindTemperature, 1},
+	"°C":  {KindTemperature, 1},
+	"V":   {KindVoltage, 1},
+	"mV":  {KindVoltage, 1e-3},
+	"A":   {KindCurrent, 1},
+	"mA":  {KindCurrent, 1e-3},
+	"W":   {KindPower, 1},
+	"mW":  {KindPower, 1e-3},
+	"uW":  {KindPower, 1e-6},
+	"kW":  {KindPower, 1e3},
+	"MW":  {KindPower, 1e6},
+	"J":   {KindEnergy, 1},
+	"mJ":  {KindEnergy, 1e-3},
+	"uJ":  {KindEnergy, 1e-6},
+	"kJ":  {KindEnergy, 1e3},
+	"MJ":  {KindEnergy, 1e6},
+	"RPM": {KindFan, 1},
+	"%RH": {KindHumidity, 1},
+	"%":   {KindPWM, 1},
+}
+
+// Reading is a single sensor reading with a numeric value in the base unit of
+// its kind. It is encoded as part of a SensorsReport.
+type Reading struct {
+	Chip       string             // Chip is the name of the chip, e.g. tmp75-i2c-3-48
+	Adapter    string             // Adapter is the bus the chip is on, e.g. ast_i2c.3, if known
+	Label      string             // Label is the name of the sensor on the chip
+	Channel    string             // Channel is the hwmon channel of the sensor, e.g. temp1, if known
+	Location   string             // Location is where the sensor physically is, if known
+	Kind       Kind               // Kind is the quantity measured
+	Value      float64            // Value is the reading in the base unit of Kind
+	Invalid    bool               // Invalid is set if the chip reports a fault, and Value is then 0
+	Thresholds map[string]float64 // Thresholds, e.g. high or crit, in the same unit
+	Alarms     map[string]bool    // Alarms, e.g. alarm or crit_alarm, reported by the chip
+	Beep       bool               // Beep is set if the chip beeps when an alarm of the sensor is raised
+}
+
+// Decode converts the data held by a cache to readings.
+type Decode func(data []byte) ([]Reading, error)
+
+// Rewrite applies fn to the readings in data, as held by the cache of a
+// monitor, and returns data with the readings fn returns in their place.
+// Whatever fn leaves unchanged is kept as it was.
+type Rewrite func(data []byte, fn func([]Reading) []Reading) ([]byte, error)
+
+// Unit returns the base unit of readings of kind k.
+func (k Kind) Unit() string {
+	switch k {
+	case KindTemperature:
+		return "celsius"
+	case KindVoltage:
+		return "volts"
+	case KindCurrent:
+		return "amperes"
+	case KindPower:
+		return "watts"
+	case KindEnergy:
+		return "joules"
+	case KindFan:
+		return "rpm"
+	case KindHumidity, KindPWM:
+		return "percent"
+	}
+
+	return ""
+}
+
+// Symbol returns the unit printed by the sensors command for readings of kind
+// k.
+func (k Kind) Symbol() string {
+	switch k {
+	case KindTemperature:
+		return "C"
+	case KindVoltage:
+		return "V"
+	case KindCurrent:
+		return "A"
+	case KindPower:
+		return "W"
+	case KindEnergy:
+		return "J"
+	case KindFan:
+		return "RPM"
+	case KindHumidity:
+		return "%RH"
+	case KindPWM:
+		return "%"
+	}
+
+	return ""
+}
+
+// FormatValue formats a value as the sensors command prints it, e.g. "+26.5 C"
+// or "7500 RPM", so that it can be parsed by ParseValue. Intrusion is
+// formatted as "ALARM" or "OK".
+func FormatValue(k Kind, value float64) string {
+	switch k {
+	case KindIntrusion:
+		if value != 0 {
+			return "ALARM"
+		}
+		return "OK"
+	case KindUnknown:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	// sensors prints a sign on temperatures, voltages and currents.
+	sign := ""
+	if value >= 0 && (k == KindTemperature || k == KindVoltage || k == KindCurrent) {
+		sign = "+"
+	}
+
+	return sign + strconv.FormatFloat(value, 'f', k.precision(), 64) + " " + k.Symbol()
+}
+
+// formatReading formats the value of r as FormatValue does, or as "FAULT" if
+// it is invalid, as the sensors command prints it.
+func formatReading(r Reading) string {
+	if r.Invalid {
+		return "FAULT"
+	}
+
+	return FormatValue(r.Kind, r.Value)
+}
+
+// precision returns the number of decimal places sensors prints for readings
+// of kind k.
+func (k Kind) precision() int {
+	switch k {
+	case KindTemperature, KindHumidity:
+		return 1
+	case KindFan:
+		return 0
+	}
+
+	return 2
+}
+
+// ParseValue parses a value printed by the sensors command, e.g. "+26.5 C",
+// "+27.8°C" or "7500 RPM", returning the value in the base unit of its kind.
+// Values which are not finite are rejected, since they can not be encoded as
+// JSON.
+func ParseValue(s string) (float64, Kind, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 1 {
+		// Some units are printed without a space, e.g. "+27.8°C".
+		if i := strings.IndexFunc(fields[0], isUnit); i > 0 {
+			fields = []string{fields[0][:i], fields[0][i:]}
+		}
+	}
+	if len(fields) != 2 {
+		return 0, KindUnknown, fmt.Errorf("report: can not parse value %q", s)
+	}
+
+	unit, ok := units[fields[1]]
+	if !ok {
+		return 0, KindUnknown, fmt.Errorf("report: unknown unit in %q", s)
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, KindUnknown, err
+	}
+	// Converting to the base unit may overflow, e.g. "1e308 kW".
+	value *= unit.multiplier
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, KindUnknown, fmt.Errorf("report: value %q is not finite", s)
+	}
+
+	return value, unit.kind, nil
+}
+
+// isUnit returns whether r can only be part of a unit.
+func isUnit(r rune) bool {
+	return !strings.ContainsRune("+-.0123456789", r)
+}
+
+// ReadingsFromClassic converts a ClassicReport to readings. Values which can
+// not be parsed, such as "N/A", are skipped.
+func ReadingsFromClassic(classic ClassicReport) []Reading {
+	readings := make([]Reading, 0)
+	for _, chip := range classic.Information {
+		labels := make([]string, 0, len(chip))
+		for label := range chip {
+			if label != "name" && label != "Adapter" {
+				labels = append(labels, label)
+			}
+		}
+		sort.Strings(labels)
+
+		for _, label := range labels {
+			value, kind, err := ParseValue(chip[label])
+			if err != nil {
+				continue
+			}
+			readings = append(readings, Reading{
+				Chip:    chip["name"],
+				Adapter: chip["Adapter"],
+				Label:   label,
+				Kind:    kind,
+				Value:   value,
+			})
+		}
+	}
+
+	return readings
+}
@@ -16,10 +16,7 @@ package report
 
 // TODO(dwat): The report(s) could use some work. For example it would be
 // helpful if future formats reported the version of gosense used to generate
-// the data. We might also want to consider supporting the Prometheus v2 format
-// for easier integration with ODS and Kubernetes:
-// https://github.com/prometheus/docs/blob/master/content/docs/instrumenting/exposition_formats.md
-// https://github.com/prometheus/client_golang
+// the data.
 
 // ClassicReport is the original monitoring API.
 type ClassicReport struct {
@@ -0,0 +1,136 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SchemaVersion is the version of the SensorsReport schema. It is incremented
+// whenever a field changes meaning or is removed, but not when one is added.
+// Version 1 is the original API served at /api/sys, i.e. the classic
+// Information report and each monitor's native format, so the first
+// SensorsReport, served at /api/v2/sys, is version 2.
+const SchemaVersion = 2
+
+// SensorsContentType is the content type of a SensorsReport.
+const SensorsContentType = "application/vnd.gosense.sensors.v2+json"
+
+// SensorsReport is the normalized representation of a monitor's readings,
+// produced by every monitor regardless of where its readings come from, e.g.
+//
+//	{
+//	  "version": 2,
+//	  "monitor": "csensors",
+//	  "time": "2020-01-02T03:04:05Z",
+//	  "readings": [
+//	    {
+//	      "chip": "tmp75-i2c-3-48",
+//	      "adapter": "ast_i2c.3",
+//	      "label": "Outlet Middle Temp",
+//	      "kind": "temperature",
+//	      "value": 26.5,
+//	      "unit": "celsius",
+//	      "thresholds": {"max": 80, "max_hyst": 75},
+//	      "alarms": {"alarm": false}
+//	    }
+//	  ]
+//	}
+type SensorsReport struct {
+	Version  int       `json:"version"`  // Version is SchemaVersion
+	Monitor  string    `json:"monitor"`  // Monitor is the name of the monitor
+	Time     time.Time `json:"time"`     // Time is when the readings were taken
+	Readings []Reading `json:"readings"` // Readings in the base unit of their kind
+}
+
+// NewSensorsReport returns the SensorsReport of a Document.
+func NewSensorsReport(d Document) SensorsReport {
+	readings := d.Readings
+	if readings == nil {
+		readings = make([]Reading, 0)
+	}
+
+	return SensorsReport{Version: SchemaVersion, Monitor: d.Monitor, Time: d.Time, Readings: readings}
+}
+
+// RewriteReadings is a Rewrite for the monitors whose cache holds readings
+// encoded as a JSON array.
+func RewriteReadings(data []byte, fn func([]Reading) []Reading) ([]byte, error) {
+	var readings []Reading
+	if err := json.Unmarshal(data, &readings); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(fn(readings))
+}
+
+// reading is the encoding of a Reading. The unit is derived from the kind so
+// it is not stored in Reading.
+type reading struct {
+	Chip       string             `json:"chip"`
+	Adapter    string             `json:"adapter,omitempty"`
+	Label      string             `json:"label"`
+	Channel    string             `json:"channel,omitempty"`
+	Location   string             `json:"location,omitempty"`
+	Kind       Kind               `json:"kind"`
+	Value      float64            `json:"value"`
+	Invalid    bool               `json:"invalid,omitempty"`
+	Unit       string             `json:"unit"`
+	Thresholds map[string]float64 `json:"thresholds,omitempty"`
+	Alarms     map[string]bool    `json:"alarms,omitempty"`
+	Beep       bool               `json:"beep,omitempty"`
+}
+
+// MarshalJSON encodes the reading with its unit.
+func (r Reading) MarshalJSON() ([]byte, error) {
+	return json.Marshal(reading{
+		Chip:       r.Chip,
+		Adapter:    r.Adapter,
+		Label:      r.Label,
+		Channel:    r.Channel,
+		Location:   r.Location,
+		Kind:       r.Kind,
+		Value:      r.Value,
+		Invalid:    r.Invalid,
+		Unit:       r.Kind.Unit(),
+		Thresholds: r.Thresholds,
+		Alarms:     r.Alarms,
+		Beep:       r.Beep,
+	})
+}
+
+// UnmarshalJSON decodes a reading, ignoring its unit.
+func (r *Reading) UnmarshalJSON(data []byte) error {
+	var decoded reading
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*r = Reading{
+		Chip:       decoded.Chip,
+		Adapter:    decoded.Adapter,
+		Label:      decoded.Label,
+		Channel:    decoded.Channel,
+		Location:   decoded.Location,
+		Kind:       decoded.Kind,
+		Value:      decoded.Value,
+		Invalid:    decoded.Invalid,
+		Thresholds: decoded.Thresholds,
+		Alarms:     decoded.Alarms,
+		Beep:       decoded.Beep,
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"experimental/dwat/gosense/pkg/report"
+)
+
+// TestReadingJSON tests that readings survive a round trip through the schema
+// and are encoded with their unit.
+func TestReadingJSON(t *testing.T) {
+	expected := []report.Reading{
+		{Chip: "tmp75-i2c-3-48", Adapter: "ast_i2c.3", Label: "Outlet Middle Temp", Kind: report.KindTemperature, Value: 26.5,
+			Thresholds: map[string]float64{"high": 80, "hyst": 75}, Alarms: map[string]bool{"alarm": false}},
+		{Chip: "it8728-isa-0a30", Label: "intrusion0", Kind: report.KindIntrusion, Value: 1},
+		{Chip: "it8728-isa-0a30", Label: "temp3", Kind: report.KindTemperature, Invalid: true, Alarms: map[string]bool{"fault": true}},
+	}
+
+	encoded, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("Failed to marshal readings %v", err)
+	}
+	unit := `{"chip":"tmp75-i2c-3-48","adapter":"ast_i2c.3","label":"Outlet Middle Temp","kind":"temperature","value":26.5,"unit":"celsius",`
+	if string(encoded[1:1+len(unit)]) != unit {
+		t.Errorf("Encoded reading observed %s, expected prefix %s", encoded, unit)
+	}
+
+	var observed []report.Reading
+	if err := json.Unmarshal(encoded, &observed); err != nil {
+		t.Fatalf("Failed to unmarshal readings %v", err)
+	}
+	if !reflect.DeepEqual(expected, observed) {
+		t.Errorf("Readings observed %+v, expected %+v", observed, expected)
+	}
+}
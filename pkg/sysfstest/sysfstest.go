@@ -0,0 +1,103 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sysfstest builds fake sysfs trees for tests of monitors which read
+// sysfs, so that they can be tested without hardware.
+package sysfstest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Chip is a hwmon chip, e.g.
+//
+//	sysfstest.Chip{Index: 0, Device: "platform/coretemp.0", Name: "coretemp",
+//		Attributes: map[string]string{"temp1_input": "42000"}}
+//
+// is linked from class/hwmon/hwmon0 to devices/platform/coretemp.0/hwmon/hwmon0
+// which holds the name and attributes of the chip.
+type Chip struct {
+	Index      int               // Index numbers the hwmon device, e.g. 0 for hwmon0
+	Device     string            // Device is the path of the chip's device under devices
+	Name       string            // Name is the name attribute of the chip
+	Legacy     bool              // Legacy keeps the attributes in the device, as older drivers do
+	Attributes map[string]string // Attributes of the chip, e.g. temp1_input, without a newline
+}
+
+// New returns the root of a new sysfs in a temporary directory with chips.
+func New(t testing.TB, chips ...Chip) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for _, chip := range chips {
+		Add(t, root, chip)
+	}
+
+	return root
+}
+
+// Add adds chip to the sysfs at root and returns the directory holding its
+// attributes.
+func Add(t testing.TB, root string, chip Chip) string {
+	t.Helper()
+
+	hwmon := fmt.Sprintf("hwmon%d", chip.Index)
+	device := filepath.Join(root, "devices", chip.Device)
+	dir := filepath.Join(device, "hwmon", hwmon)
+	mkdir(t, dir)
+	link(t, filepath.Join("..", "..", "devices", chip.Device, "hwmon", hwmon), filepath.Join(root, "class", "hwmon", hwmon))
+
+	if chip.Legacy {
+		link(t, filepath.Join("..", ".."), filepath.Join(dir, "device"))
+		dir = device
+	}
+	WriteAttribute(t, dir, "name", chip.Name)
+	for name, value := range chip.Attributes {
+		WriteAttribute(t, dir, name, value)
+	}
+
+	return dir
+}
+
+// WriteAttribute writes value, followed by a newline as the kernel does, to
+// the attribute name in dir.
+func WriteAttribute(t testing.TB, dir, name, value string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write attribute %s %v", name, err)
+	}
+}
+
+// mkdir makes dir and any directories above it.
+func mkdir(t testing.TB, dir string) {
+	t.Helper()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to make directory %v", err)
+	}
+}
+
+// link makes a symbolic link at path to target.
+func link(t testing.TB, target, path string) {
+	t.Helper()
+
+	mkdir(t, filepath.Dir(path))
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatalf("Failed to link %s %v", path, err)
+	}
+}